@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AuditEntry describes one request handled by withAuditSink.
+type AuditEntry struct {
+	Time       time.Time
+	Method     string
+	Path       string
+	Status     int
+	Duration   time.Duration
+	RemoteAddr string
+}
+
+const (
+	// auditMaxAttempts is how many times an entry is offered to the sink
+	// before it is dropped.
+	auditMaxAttempts = 5
+	// auditRetryBase and auditRetryMax bound the backoff between attempts.
+	auditRetryBase = 10 * time.Millisecond
+	auditRetryMax  = time.Second
+)
+
+// withAuditSink records every request as an AuditEntry and delivers it to
+// sink from a background goroutine, retrying failures with exponential
+// backoff. Entries wait in a channel of the given buffer size; when it is
+// full new entries are dropped so requests never block on the sink.
+//
+// The returned close function stops accepting entries and blocks until
+// those already buffered have been delivered or given up on. Requests
+// served after close are not audited.
+func withAuditSink(sink func(entry AuditEntry) error, buffer int) (func(http.Handler) http.Handler, func()) {
+	entries := make(chan AuditEntry, buffer)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		for e := range entries {
+			next, _ := backoff(auditRetryBase, auditRetryMax)
+			for attempt := 1; sink(e) != nil && attempt < auditMaxAttempts; attempt++ {
+				time.Sleep(next())
+			}
+		}
+	}()
+
+	var mu sync.RWMutex
+	closed := false
+
+	mw := func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := newStatusRecorder(w)
+			h.ServeHTTP(rec, r)
+			e := AuditEntry{
+				Time:       start,
+				Method:     r.Method,
+				Path:       r.URL.Path,
+				Status:     rec.status,
+				Duration:   time.Since(start),
+				RemoteAddr: r.RemoteAddr,
+			}
+
+			mu.RLock()
+			defer mu.RUnlock()
+			if closed {
+				return
+			}
+			select {
+			case entries <- e:
+			default:
+			}
+		})
+	}
+
+	closeFn := func() {
+		mu.Lock()
+		if !closed {
+			closed = true
+			close(entries)
+		}
+		mu.Unlock()
+		<-done
+	}
+
+	return mw, closeFn
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// auditSink is a sink that records delivered entries and fails the first
+// failures attempts.
+type auditSink struct {
+	mu       sync.Mutex
+	failures int
+	attempts int
+	entries  []AuditEntry
+}
+
+func (s *auditSink) deliver(e AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts++
+	if s.attempts <= s.failures {
+		return errors.New("sink unavailable")
+	}
+	s.entries = append(s.entries, e)
+	return nil
+}
+
+func TestWithAuditSink(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		wantAttempts int
+		wantEntries  int
+	}{
+		{"healthy sink", 0, 1, 1},
+		{"temporarily failing sink", 2, 3, 1},
+		{"sink never recovers", 100, auditMaxAttempts, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &auditSink{failures: tt.failures}
+			mw, closeFn := withAuditSink(sink.deliver, 4)
+			h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/orders", nil))
+			closeFn()
+
+			if sink.attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", sink.attempts, tt.wantAttempts)
+			}
+			if len(sink.entries) != tt.wantEntries {
+				t.Fatalf("delivered %d entries, want %d", len(sink.entries), tt.wantEntries)
+			}
+			if tt.wantEntries > 0 {
+				e := sink.entries[0]
+				if e.Method != http.MethodPost || e.Path != "/orders" || e.Status != http.StatusAccepted {
+					t.Errorf("entry = %+v, want POST /orders 202", e)
+				}
+			}
+		})
+	}
+}
+
+func TestWithAuditSinkDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	mw, closeFn := withAuditSink(func(AuditEntry) error {
+		<-release
+		return nil
+	}, 1)
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		// One entry is held by the stuck sink, one fills the buffer and
+		// the rest are dropped.
+		for range 10 {
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		}
+	}()
+	select {
+	case <-served:
+	case <-time.After(time.Second):
+		t.Fatal("requests blocked on a stuck sink")
+	}
+	close(release)
+	closeFn()
+
+	// Requests after close are served but not audited.
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status after close = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by a circuit breaker that is refusing calls.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// circuitBreaker returns a call wrapper that trips open after maxFailures
+// consecutive errors. While open, calls fail fast with ErrCircuitOpen.
+// Once resetAfter has passed a single trial call is let through
+// (half-open): success closes the circuit again, failure reopens it.
+func circuitBreaker(maxFailures int, resetAfter time.Duration) func(fn func() error) error {
+	return circuitBreakerClock(realClock{}, maxFailures, resetAfter)
+}
+
+// circuitBreakerClock is circuitBreaker with time taken from clock.
+func circuitBreakerClock(clock Clock, maxFailures int, resetAfter time.Duration) func(fn func() error) error {
+	var mu sync.Mutex
+	failures := 0
+	open := false
+	trial := false
+	var openedAt time.Time
+
+	return func(fn func() error) error {
+		mu.Lock()
+		if open {
+			if trial || clock.Now().Sub(openedAt) < resetAfter {
+				mu.Unlock()
+				return ErrCircuitOpen
+			}
+			// Half-open: let this call through as the trial.
+			trial = true
+		}
+		mu.Unlock()
+
+		err := fn()
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err == nil {
+			failures = 0
+			open = false
+			trial = false
+			return nil
+		}
+		failures++
+		if trial || failures >= maxFailures {
+			open = true
+			trial = false
+			openedAt = clock.Now()
+		}
+		return err
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	const resetAfter = time.Minute
+	clock := newFakeClock()
+	call := circuitBreakerClock(clock, 2, resetAfter)
+	errBoom := errors.New("boom")
+	fail := func() error { return errBoom }
+	ok := func() error { return nil }
+
+	steps := []struct {
+		name    string
+		advance time.Duration
+		fn      func() error
+		want    error
+	}{
+		{"closed: first failure", 0, fail, errBoom},
+		{"closed: success resets count", 0, ok, nil},
+		{"closed: failure 1", 0, fail, errBoom},
+		{"closed: failure 2 opens", 0, fail, errBoom},
+		{"open: fast-fails", 0, ok, ErrCircuitOpen},
+		{"open: still before reset", resetAfter - time.Second, ok, ErrCircuitOpen},
+		{"half-open: trial fails, reopens", time.Second, fail, errBoom},
+		{"reopened: fast-fails", 0, ok, ErrCircuitOpen},
+		{"half-open: trial succeeds, closes", resetAfter, ok, nil},
+		{"closed again", 0, ok, nil},
+		{"closed: one failure stays closed", 0, fail, errBoom},
+		{"closed: still calls fn", 0, ok, nil},
+	}
+	for _, step := range steps {
+		clock.Advance(step.advance)
+		ran := false
+		err := call(func() error {
+			ran = true
+			return step.fn()
+		})
+		if !errors.Is(err, step.want) {
+			t.Errorf("%s: err = %v, want %v", step.name, err, step.want)
+		}
+		if wantRan := step.want != ErrCircuitOpen; ran != wantRan {
+			t.Errorf("%s: fn ran = %v, want %v", step.name, ran, wantRan)
+		}
+	}
+}
+
+func TestCircuitBreakerSingleTrial(t *testing.T) {
+	clock := newFakeClock()
+	call := circuitBreakerClock(clock, 1, time.Second)
+	call(func() error { return errors.New("boom") })
+	clock.Advance(time.Second)
+
+	// While the trial call is running, other calls still fast-fail.
+	inTrial := make(chan struct{})
+	finish := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- call(func() error {
+			close(inTrial)
+			<-finish
+			return nil
+		})
+	}()
+	<-inTrial
+	if err := call(func() error { return nil }); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("call during trial = %v, want ErrCircuitOpen", err)
+	}
+	close(finish)
+	if err := <-done; err != nil {
+		t.Errorf("trial = %v, want nil", err)
+	}
+}
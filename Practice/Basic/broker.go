@@ -0,0 +1,59 @@
+package main
+
+import "sync"
+
+// Broker is a typed pub/sub hub. Each subscriber gets its own buffered
+// channel; a subscriber whose buffer is full misses messages rather than
+// slowing down publishers. It is safe for concurrent use.
+type Broker[T any] struct {
+	buffer int
+
+	mu   sync.RWMutex
+	subs map[string]map[chan T]struct{}
+}
+
+// NewBroker returns a Broker whose subscriber channels hold up to buffer
+// undelivered messages.
+func NewBroker[T any](buffer int) *Broker[T] {
+	return &Broker[T]{buffer: buffer, subs: make(map[string]map[chan T]struct{})}
+}
+
+// Subscribe returns a channel receiving messages published to topic and
+// a closure that unsubscribes and closes the channel. Calling
+// unsubscribe more than once is harmless.
+func (b *Broker[T]) Subscribe(topic string) (<-chan T, func()) {
+	ch := make(chan T, b.buffer)
+
+	b.mu.Lock()
+	if b.subs[topic] == nil {
+		b.subs[topic] = make(map[chan T]struct{})
+	}
+	b.subs[topic][ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.subs[topic], ch)
+			if len(b.subs[topic]) == 0 {
+				delete(b.subs, topic)
+			}
+			close(ch)
+		})
+	}
+	return ch, unsubscribe
+}
+
+// Publish offers msg to every subscriber of topic without blocking.
+func (b *Broker[T]) Publish(topic string, msg T) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for ch := range b.subs[topic] {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"testing"
+)
+
+// drain returns the messages already buffered in ch without blocking.
+func drain[T any](ch <-chan T) []T {
+	var got []T
+	for {
+		select {
+		case v, ok := <-ch:
+			if !ok {
+				return got
+			}
+			got = append(got, v)
+		default:
+			return got
+		}
+	}
+}
+
+func TestBrokerMultipleSubscribers(t *testing.T) {
+	b := NewBroker[string](4)
+	a, unsubA := b.Subscribe("orders")
+	defer unsubA()
+	c, unsubC := b.Subscribe("orders")
+	defer unsubC()
+	other, unsubOther := b.Subscribe("refunds")
+	defer unsubOther()
+
+	b.Publish("orders", "o1")
+	b.Publish("orders", "o2")
+	b.Publish("nobody-listens", "x")
+
+	for name, ch := range map[string]<-chan string{"a": a, "c": c} {
+		if got, want := drain(ch), []string{"o1", "o2"}; !slices.Equal(got, want) {
+			t.Errorf("subscriber %s got %v, want %v", name, got, want)
+		}
+	}
+	if got := drain(other); len(got) != 0 {
+		t.Errorf("refunds subscriber got %v, want nothing", got)
+	}
+}
+
+func TestBrokerUnsubscribe(t *testing.T) {
+	b := NewBroker[int](1)
+	ch, unsub := b.Subscribe("t")
+	unsub()
+	unsub() // harmless
+
+	if _, ok := <-ch; ok {
+		t.Error("channel still open after unsubscribe")
+	}
+	b.Publish("t", 1) // must not panic on the closed channel
+	if len(b.subs) != 0 {
+		t.Errorf("broker still tracks %d topics after the last unsubscribe", len(b.subs))
+	}
+}
+
+func TestBrokerSlowSubscriberDrops(t *testing.T) {
+	b := NewBroker[int](2)
+	slow, unsubSlow := b.Subscribe("t")
+	defer unsubSlow()
+	fast, unsubFast := b.Subscribe("t")
+	defer unsubFast()
+
+	var fastGot []int
+	for i := range 5 {
+		b.Publish("t", i) // never blocks, even though slow isn't reading
+		fastGot = append(fastGot, <-fast)
+	}
+	if want := []int{0, 1, 2, 3, 4}; !slices.Equal(fastGot, want) {
+		t.Errorf("fast subscriber got %v, want %v", fastGot, want)
+	}
+	if got, want := drain(slow), []int{0, 1}; !slices.Equal(got, want) {
+		t.Errorf("slow subscriber got %v, want the first %v and the rest dropped", got, want)
+	}
+}
+
+func TestBrokerConcurrent(t *testing.T) {
+	b := NewBroker[int](100)
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			ch, unsub := b.Subscribe("t")
+			drain(ch)
+			unsub()
+		}()
+		go func() {
+			defer wg.Done()
+			for i := range 10 {
+				b.Publish("t", i)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// performed prints and returns the confirmation message for a button
+// press.
+func performed(userID, action string) string {
+	msg := fmt.Sprintf("User %s performed %s", userID, action)
+	fmt.Println(msg)
+	return msg
+}
+
+// createButtonHandlerE is like createButtonHandler but runs do for the
+// given user and action. The Result holds the confirmation message, which
+// is only printed when do succeeds, or do's error.
+func createButtonHandlerE(userID string, action string, do func(userID, action string) error) func() Result[string] {
+	return func() Result[string] {
+		if err := do(userID, action); err != nil {
+			return Err[string](err)
+		}
+		return Ok(performed(userID, action))
+	}
+}
+
+// createButtonHandlerCtx returns a handler that honours ctx: if ctx is
+// already cancelled or past its deadline, the action is skipped and the
+// Result holds ctx.Err().
+func createButtonHandlerCtx(userID string, action string) func(context.Context) Result[string] {
+	return func(ctx context.Context) Result[string] {
+		if err := ctx.Err(); err != nil {
+			return Err[string](err)
+		}
+		return Ok(performed(userID, action))
+	}
+}
+
+// withRetry returns a closure that calls fn up to attempts times, waiting
+// backoff before the first retry and doubling the wait after each failure.
+// It returns nil on the first success or the last error once all attempts
+// are used up.
+func withRetry(attempts int, backoff time.Duration, fn func() error) func() error {
+	return func() error {
+		var err error
+		delay := backoff
+		for i := 0; i < attempts; i++ {
+			if i > 0 {
+				time.Sleep(delay)
+				delay *= 2
+			}
+			if err = fn(); err == nil {
+				return nil
+			}
+		}
+		return err
+	}
+}
+
+// HandlerRegistry maps a user and action to the button handler registered
+// for them. It is safe for concurrent use.
+type HandlerRegistry struct {
+	handlers *Registry[string, func()]
+}
+
+// NewHandlerRegistry returns an empty HandlerRegistry.
+func NewHandlerRegistry() *HandlerRegistry {
+	return &HandlerRegistry{handlers: NewRegistry[string, func()]()}
+}
+
+func registryKey(userID, action string) string {
+	return userID + "/" + action
+}
+
+// Register stores h for userID and action, replacing any earlier handler.
+func (hr *HandlerRegistry) Register(userID, action string, h func()) {
+	hr.handlers.Set(registryKey(userID, action), h)
+}
+
+// Dispatch runs the handler registered for userID and action, or returns
+// an error if there is none.
+func (hr *HandlerRegistry) Dispatch(userID, action string) error {
+	h, ok := hr.handlers.Get(registryKey(userID, action))
+	if !ok {
+		return fmt.Errorf("no handler registered for user %s action %s", userID, action)
+	}
+	h()
+	return nil
+}
+
+// safeHandler wraps a button handler so that a panic inside h is
+// recovered and logged to the standard logger instead of propagating.
+func safeHandler(h func()) func() {
+	logger := stdLogger(log.Default())
+	return func() {
+		defer func() {
+			if rec := recover(); rec != nil {
+				logger.Info("Panic in button handler", "error", rec)
+			}
+		}()
+		h()
+	}
+}
+
+// fanOut returns a handler that runs each of handlers in order, e.g. save
+// followed by audit and notify. With no handlers it does nothing.
+func fanOut(handlers ...func()) func() {
+	return func() {
+		for _, h := range handlers {
+			h()
+		}
+	}
+}
+
+// fanOutConcurrent runs every handler in its own goroutine, waits for all
+// of them and returns their errors combined with errors.Join (nil if all
+// succeeded).
+func fanOutConcurrent(handlers ...func() error) error {
+	errs := make([]error, len(handlers))
+	var wg sync.WaitGroup
+	for i, h := range handlers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = h()
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// validateThen returns a handler for userID and action that runs each
+// validator in order and calls h only if all of them pass. The first
+// validation error is returned and h is skipped.
+func validateThen(userID string, action string, validators []func(userID, action string) error, h func()) func() error {
+	return func() error {
+		for _, validate := range validators {
+			if err := validate(userID, action); err != nil {
+				return err
+			}
+		}
+		h()
+		return nil
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCreateButtonHandlerE(t *testing.T) {
+	errSave := errors.New("db unavailable")
+	tests := []struct {
+		name    string
+		doErr   error
+		wantErr error
+	}{
+		{"success", nil, nil},
+		{"error", errSave, errSave},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotUser, gotAction string
+			h := createButtonHandlerE("user123", "save", func(userID, action string) error {
+				gotUser, gotAction = userID, action
+				return tt.doErr
+			})
+			msg, err := h().Unwrap()
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("h() error = %v, want %v", err, tt.wantErr)
+			}
+			if want := "User user123 performed save"; tt.wantErr == nil && msg != want {
+				t.Errorf("h() value = %q, want %q", msg, want)
+			}
+			if gotUser != "user123" || gotAction != "save" {
+				t.Errorf("do called with (%q, %q), want (\"user123\", \"save\")", gotUser, gotAction)
+			}
+		})
+	}
+}
+
+func TestCreateButtonHandlerCtx(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want error
+	}{
+		{"live", context.Background(), nil},
+		{"cancelled", cancelled, context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := createButtonHandlerCtx("user123", "save")
+			r := h(tt.ctx)
+			if _, err := r.Unwrap(); !errors.Is(err, tt.want) {
+				t.Errorf("h(ctx) error = %v, want %v", err, tt.want)
+			}
+			if r.IsErr() != (tt.want != nil) {
+				t.Errorf("h(ctx).IsErr() = %v, want %v", r.IsErr(), tt.want != nil)
+			}
+		})
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	errTransient := errors.New("transient")
+	tests := []struct {
+		name      string
+		attempts  int
+		failFirst int
+		wantCalls int
+		wantErr   error
+	}{
+		{"first try", 3, 0, 1, nil},
+		{"third try", 3, 2, 3, nil},
+		{"exhausted", 3, 5, 3, errTransient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			var stamps []time.Time
+			f := withRetry(tt.attempts, time.Millisecond, func() error {
+				calls++
+				stamps = append(stamps, time.Now())
+				if calls <= tt.failFirst {
+					return errTransient
+				}
+				return nil
+			})
+			if err := f(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("f() = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("fn called %d times, want %d", calls, tt.wantCalls)
+			}
+			// Waits are 1ms then 2ms.
+			for i := 1; i < len(stamps); i++ {
+				if want := time.Millisecond << (i - 1); stamps[i].Sub(stamps[i-1]) < want {
+					t.Errorf("wait before attempt %d = %v, want at least %v", i+1, stamps[i].Sub(stamps[i-1]), want)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlerRegistry(t *testing.T) {
+	hr := NewHandlerRegistry()
+	var ran []string
+	hr.Register("alice", "save", func() { ran = append(ran, "alice/save") })
+	hr.Register("bob", "save", func() { ran = append(ran, "bob/save") })
+	hr.Register("alice", "save", func() { ran = append(ran, "alice/save v2") })
+
+	tests := []struct {
+		user, action string
+		wantErr      bool
+	}{
+		{"alice", "save", false},
+		{"bob", "save", false},
+		{"bob", "delete", true},
+		{"carol", "save", true},
+	}
+	for _, tt := range tests {
+		err := hr.Dispatch(tt.user, tt.action)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Dispatch(%q, %q) = %v, want error %v", tt.user, tt.action, err, tt.wantErr)
+		}
+	}
+	if got, want := strings.Join(ran, ","), "alice/save v2,bob/save"; got != want {
+		t.Errorf("ran %s, want %s", got, want)
+	}
+}
+
+func TestSafeHandler(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	calls, after := 0, false
+	boom := func() { panic("button broke") }
+	h := safeHandler(func() {
+		calls++
+		boom()
+		after = true
+	})
+	h()
+
+	if calls != 1 || after {
+		t.Errorf("calls = %d, after = %v; want 1, false", calls, after)
+	}
+	if !strings.Contains(buf.String(), "Panic in button handler error=button broke") {
+		t.Errorf("log = %q, want the recovered value", buf.String())
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	var ran []string
+	record := func(name string) func() {
+		return func() { ran = append(ran, name) }
+	}
+	fanOut(record("save"), record("audit"), record("notify"))()
+	if got, want := strings.Join(ran, ","), "save,audit,notify"; got != want {
+		t.Errorf("ran %s, want %s", got, want)
+	}
+
+	// No handlers is a no-op rather than a panic.
+	fanOut()()
+}
+
+func TestFanOutConcurrent(t *testing.T) {
+	t.Run("all run concurrently", func(t *testing.T) {
+		const n = 5
+		// Every handler blocks until all n have started, so this only
+		// finishes if they run at the same time.
+		var started sync.WaitGroup
+		started.Add(n)
+		handlers := make([]func() error, n)
+		for i := range handlers {
+			handlers[i] = func() error {
+				started.Done()
+				started.Wait()
+				return nil
+			}
+		}
+		done := make(chan error, 1)
+		go func() { done <- fanOutConcurrent(handlers...) }()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("fanOutConcurrent() = %v, want nil", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("handlers did not run concurrently")
+		}
+	})
+
+	t.Run("errors joined", func(t *testing.T) {
+		errA, errB := errors.New("a failed"), errors.New("b failed")
+		err := fanOutConcurrent(
+			func() error { return errA },
+			func() error { return nil },
+			func() error { return errB },
+		)
+		if !errors.Is(err, errA) || !errors.Is(err, errB) {
+			t.Errorf("fanOutConcurrent() = %v, want both %v and %v", err, errA, errB)
+		}
+	})
+
+	t.Run("Result handlers via errOnly", func(t *testing.T) {
+		errDisk := errors.New("disk full")
+		err := fanOutConcurrent(
+			errOnly(createButtonHandlerE("user123", "save", func(string, string) error { return nil })),
+			errOnly(createButtonHandlerE("user123", "save", func(string, string) error { return errDisk })),
+		)
+		if !errors.Is(err, errDisk) {
+			t.Errorf("fanOutConcurrent() = %v, want %v", err, errDisk)
+		}
+	})
+
+	t.Run("no handlers", func(t *testing.T) {
+		if err := fanOutConcurrent(); err != nil {
+			t.Errorf("fanOutConcurrent() = %v, want nil", err)
+		}
+	})
+}
+
+func TestValidateThen(t *testing.T) {
+	errBlocked := errors.New("user blocked")
+	pass := func(userID, action string) error { return nil }
+	block := func(userID, action string) error { return errBlocked }
+	tests := []struct {
+		name       string
+		validators []func(userID, action string) error
+		wantCalls  int // validators run
+		wantErr    error
+		wantRan    bool
+	}{
+		{"all pass", []func(string, string) error{pass, pass}, 2, nil, true},
+		{"short-circuit", []func(string, string) error{pass, block, pass}, 2, errBlocked, false},
+		{"no validators", nil, 0, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			counted := make([]func(string, string) error, len(tt.validators))
+			for i, v := range tt.validators {
+				counted[i] = func(userID, action string) error {
+					calls++
+					if userID != "user123" || action != "save" {
+						t.Errorf("validator got (%q, %q)", userID, action)
+					}
+					return v(userID, action)
+				}
+			}
+			ran := false
+			err := validateThen("user123", "save", counted, func() { ran = true })()
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls || ran != tt.wantRan {
+				t.Errorf("validators run = %d, h ran = %v; want %d, %v", calls, ran, tt.wantCalls, tt.wantRan)
+			}
+		})
+	}
+}
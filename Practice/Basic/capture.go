@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// capturedResponse is a snapshot of what a handler wrote.
+type capturedResponse struct {
+	Status  int
+	Header  http.Header
+	Body    []byte
+	Flushes int
+}
+
+// captureWriter records the status, headers and body written through it
+// while passing everything on to the wrapped ResponseWriter. With a nil
+// ResponseWriter it only records, which is handy in tests.
+type captureWriter struct {
+	w           http.ResponseWriter
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+	flushes     int
+}
+
+func newCaptureWriter(w http.ResponseWriter) *captureWriter {
+	cw := &captureWriter{w: w, status: http.StatusOK}
+	if w != nil {
+		cw.header = w.Header()
+	} else {
+		cw.header = make(http.Header)
+	}
+	return cw
+}
+
+func (cw *captureWriter) Header() http.Header {
+	return cw.header
+}
+
+func (cw *captureWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.status = code
+	cw.wroteHeader = true
+	if cw.w != nil {
+		cw.w.WriteHeader(code)
+	}
+}
+
+func (cw *captureWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	cw.body.Write(b)
+	if cw.w == nil {
+		return len(b), nil
+	}
+	return cw.w.Write(b)
+}
+
+// Flush forwards to the wrapped writer when it supports http.Flusher.
+func (cw *captureWriter) Flush() {
+	cw.flushes++
+	if f, ok := cw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Captured returns a copy of everything written so far.
+func (cw *captureWriter) Captured() capturedResponse {
+	return capturedResponse{
+		Status:  cw.status,
+		Header:  cw.header.Clone(),
+		Body:    bytes.Clone(cw.body.Bytes()),
+		Flushes: cw.flushes,
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCaptureWriter(t *testing.T) {
+	tests := []struct {
+		name    string
+		wrapped bool
+	}{
+		{"pass-through", true},
+		{"record only", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var under *httptest.ResponseRecorder
+			var cw *captureWriter
+			if tt.wrapped {
+				under = httptest.NewRecorder()
+				cw = newCaptureWriter(under)
+			} else {
+				cw = newCaptureWriter(nil)
+			}
+
+			var w http.ResponseWriter = cw
+			w.Header().Set("X-Test", "yes")
+			w.WriteHeader(http.StatusCreated)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("hello "))
+			w.(http.Flusher).Flush()
+			w.Write([]byte("world"))
+
+			got := cw.Captured()
+			if got.Status != http.StatusCreated || string(got.Body) != "hello world" || got.Flushes != 1 {
+				t.Errorf("captured %d %q flushes=%d, want 201 %q flushes=1", got.Status, got.Body, got.Flushes, "hello world")
+			}
+			if got.Header.Get("X-Test") != "yes" {
+				t.Errorf("captured header X-Test = %q, want yes", got.Header.Get("X-Test"))
+			}
+			if !tt.wrapped {
+				return
+			}
+			if under.Code != http.StatusCreated || under.Body.String() != "hello world" || !under.Flushed {
+				t.Errorf("wrapped writer got %d %q flushed=%v", under.Code, under.Body, under.Flushed)
+			}
+		})
+	}
+}
+
+func TestCaptureWriterImplicitStatus(t *testing.T) {
+	cw := newCaptureWriter(nil)
+	cw.Write([]byte("x"))
+	if got := cw.Captured(); got.Status != http.StatusOK {
+		t.Errorf("status = %d, want 200", got.Status)
+	}
+
+	// The snapshot is a copy.
+	snap := cw.Captured()
+	snap.Body[0] = 'y'
+	snap.Header.Set("X-Late", "1")
+	if got := cw.Captured(); string(got.Body) != "x" || got.Header.Get("X-Late") != "" {
+		t.Errorf("Captured() shares state with an earlier snapshot: %+v", got)
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+import "time"
+
+// Clock reports the current time. Time-based helpers take a Clock so
+// tests can control time instead of sleeping.
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock is the Clock backed by time.Now.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
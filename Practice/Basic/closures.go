@@ -0,0 +1,562 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/exp/constraints"
+)
+
+// concurrentAdder behaves like adder but is safe to call from multiple
+// goroutines: the captured sum is guarded by a mutex.
+func concurrentAdder() func(int) int {
+	var mu sync.Mutex
+	sum := 0
+	return func(x int) int {
+		mu.Lock()
+		defer mu.Unlock()
+		sum += x
+		return sum
+	}
+}
+
+// accumulator is the generic form of adder: each call adds x to the
+// running total and returns the new total.
+func accumulator[T constraints.Integer | constraints.Float]() func(T) T {
+	var sum T
+	return func(x T) T {
+		sum += x
+		return sum
+	}
+}
+
+// counter returns an increment function and a reset function that share
+// the same captured count. After reset, the next inc returns 1.
+func counter() (inc func() int, reset func()) {
+	count := 0
+	inc = func() int {
+		count++
+		return count
+	}
+	reset = func() {
+		count = 0
+	}
+	return inc, reset
+}
+
+// multiplier returns a closure that multiplies its argument by factor.
+// Unlike adder, the captured value never changes.
+func multiplier(factor int) func(int) int {
+	return func(x int) int {
+		return x * factor
+	}
+}
+
+// runningProduct returns a closure that keeps a cumulative product,
+// starting at 1, and returns it after each call.
+func runningProduct() func(int) int {
+	product := 1
+	return func(x int) int {
+		product *= x
+		return product
+	}
+}
+
+// memoize wraps fn so each distinct input is computed only once; later
+// calls with the same argument return the cached result.
+func memoize(fn func(int) int) func(int) int {
+	cache := make(map[int]int)
+	return func(x int) int {
+		if v, ok := cache[x]; ok {
+			return v
+		}
+		v := fn(x)
+		cache[x] = v
+		return v
+	}
+}
+
+// once returns a closure that calls fn on its first invocation only. It is
+// not safe for concurrent use; see onceSafe.
+func once(fn func()) func() {
+	done := false
+	return func() {
+		if done {
+			return
+		}
+		done = true
+		fn()
+	}
+}
+
+// onceSafe is the concurrency-safe form of once, built on sync.Once.
+func onceSafe(fn func()) func() {
+	var o sync.Once
+	return func() {
+		o.Do(fn)
+	}
+}
+
+// debounce returns a closure that delays fn until d has passed without
+// another call. A burst of calls results in a single run of fn.
+func debounce(d time.Duration, fn func()) func() {
+	var mu sync.Mutex
+	var timer *time.Timer
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if timer == nil {
+			timer = time.AfterFunc(d, fn)
+			return
+		}
+		timer.Reset(d)
+	}
+}
+
+// throttle returns a closure that runs fn at most once per interval d.
+// Calls made before the interval has elapsed are dropped.
+func throttle(d time.Duration, fn func()) func() {
+	return throttleClock(realClock{}, d, fn)
+}
+
+// throttleClock is throttle with the time source supplied by clock.
+func throttleClock(clock Clock, d time.Duration, fn func()) func() {
+	var mu sync.Mutex
+	var last time.Time
+	return func() {
+		mu.Lock()
+		now := clock.Now()
+		if !last.IsZero() && now.Sub(last) < d {
+			mu.Unlock()
+			return
+		}
+		last = now
+		mu.Unlock()
+		fn()
+	}
+}
+
+// compose returns a closure that applies fns left to right. With no
+// functions it returns its argument unchanged.
+func compose(fns ...func(int) int) func(int) int {
+	return func(x int) int {
+		for _, fn := range fns {
+			x = fn(x)
+		}
+		return x
+	}
+}
+
+// cacheWithTTL is like memoize for string keys, but a cached result is
+// only reused for ttl; after that fn is called again. It is safe for
+// concurrent use.
+func cacheWithTTL(ttl time.Duration, fn func(string) string) func(string) string {
+	return cacheWithTTLClock(realClock{}, ttl, fn)
+}
+
+// cacheWithTTLClock is cacheWithTTL with expiry measured against clock.
+func cacheWithTTLClock(clock Clock, ttl time.Duration, fn func(string) string) func(string) string {
+	type entry struct {
+		value   string
+		expires time.Time
+	}
+	var mu sync.Mutex
+	cache := make(map[string]entry)
+	return func(key string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		now := clock.Now()
+		if e, ok := cache[key]; ok && now.Before(e.expires) {
+			return e.value
+		}
+		v := fn(key)
+		cache[key] = entry{value: v, expires: now.Add(ttl)}
+		return v
+	}
+}
+
+// stateMachine returns accessors for a state captured in a closure.
+// transition moves to the given state only if transitions lists it as a
+// valid next state from the current one; otherwise it returns an error
+// and leaves the state unchanged.
+func stateMachine(initial string, transitions map[string][]string) (current func() string, transition func(to string) error) {
+	state := initial
+	current = func() string {
+		return state
+	}
+	transition = func(to string) error {
+		if !slices.Contains(transitions[state], to) {
+			return fmt.Errorf("invalid transition from %q to %q", state, to)
+		}
+		state = to
+		return nil
+	}
+	return current, transition
+}
+
+// idGenerator returns a closure yielding prefix-1, prefix-2, ... It is
+// safe for concurrent use.
+func idGenerator(prefix string) func() string {
+	var n atomic.Int64
+	return func() string {
+		return prefix + "-" + strconv.FormatInt(n.Add(1), 10)
+	}
+}
+
+// backoff returns a closure yielding base, 2*base, 4*base, ... capped at
+// max, and a reset function that starts the sequence over at base.
+func backoff(base, max time.Duration) (next func() time.Duration, reset func()) {
+	cur := base
+	next = func() time.Duration {
+		d := min(cur, max)
+		if cur < max {
+			cur *= 2
+		}
+		return d
+	}
+	reset = func() {
+		cur = base
+	}
+	return next, reset
+}
+
+// backoffJitter is backoff with full jitter: each delay is drawn uniformly
+// from [0, d] where d is the next capped exponential delay, so it never
+// exceeds max. rng supplies the randomness; pass a seeded *rand.Rand for
+// reproducible delays, or nil to use the global source.
+func backoffJitter(base, max time.Duration, rng *rand.Rand) func() time.Duration {
+	next, _ := backoff(base, max)
+	randN := rand.Int64N
+	if rng != nil {
+		randN = rng.Int64N
+	}
+	return func() time.Duration {
+		d := next()
+		if d <= 0 {
+			return 0
+		}
+		return time.Duration(randN(int64(d) + 1))
+	}
+}
+
+// singleFlight returns a closure that coalesces concurrent calls sharing a
+// key: the first caller runs fn and everyone waiting on that key gets its
+// result. Once fn returns the key is forgotten, so a later call runs fn
+// again.
+func singleFlight[T any]() func(key string, fn func() (T, error)) (T, error) {
+	type call struct {
+		done chan struct{}
+		val  T
+		err  error
+	}
+	var mu sync.Mutex
+	inFlight := make(map[string]*call)
+
+	return func(key string, fn func() (T, error)) (T, error) {
+		mu.Lock()
+		if c, ok := inFlight[key]; ok {
+			mu.Unlock()
+			<-c.done
+			return c.val, c.err
+		}
+		c := &call{done: make(chan struct{})}
+		inFlight[key] = c
+		mu.Unlock()
+
+		defer func() {
+			mu.Lock()
+			delete(inFlight, key)
+			mu.Unlock()
+			close(c.done)
+		}()
+		c.val, c.err = fn()
+		return c.val, c.err
+	}
+}
+
+// batcher returns closures that group items into batches of size. add
+// buffers an item and calls flush once size items have accumulated;
+// close flushes whatever is left. Both are safe for concurrent use, and
+// flush is called while the buffer lock is held so batches are delivered
+// one at a time.
+func batcher[T any](size int, flush func([]T)) (add func(T), close func()) {
+	var mu sync.Mutex
+	buf := make([]T, 0, size)
+
+	add = func(item T) {
+		mu.Lock()
+		defer mu.Unlock()
+		buf = append(buf, item)
+		if len(buf) >= size {
+			flush(buf)
+			buf = make([]T, 0, size)
+		}
+	}
+	close = func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(buf) > 0 {
+			flush(buf)
+			buf = make([]T, 0, size)
+		}
+	}
+	return add, close
+}
+
+// rollingCounter returns a closure that records a hit at clock.Now and
+// returns how many hits fall within the last window, forgetting older
+// ones. It is safe for concurrent use.
+func rollingCounter(window time.Duration, clock Clock) func() int {
+	var mu sync.Mutex
+	var hits []time.Time
+	return func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		now := clock.Now()
+		cutoff := now.Add(-window)
+		i := 0
+		for i < len(hits) && !hits[i].After(cutoff) {
+			i++
+		}
+		hits = append(hits[i:], now)
+		return len(hits)
+	}
+}
+
+// tokenBucket returns a closure that hands out tokens from a bucket of
+// the given capacity, which starts full and regains one token every
+// refill. The closure reports whether a token was taken. It is safe for
+// concurrent use.
+func tokenBucket(capacity int, refill time.Duration, clock Clock) func() bool {
+	var mu sync.Mutex
+	tokens := capacity
+	last := clock.Now()
+	return func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		now := clock.Now()
+		if tokens < capacity && refill > 0 {
+			n := int(now.Sub(last) / refill)
+			tokens = min(capacity, tokens+n)
+			last = last.Add(time.Duration(n) * refill)
+		}
+		if tokens == capacity {
+			// A full bucket doesn't bank time towards future tokens.
+			last = now
+		}
+		if tokens == 0 {
+			return false
+		}
+		tokens--
+		return true
+	}
+}
+
+// semaphore returns closures over a buffered channel with n slots:
+// acquire blocks until a slot is free, tryAcquire takes one only if it is
+// free right now, and release gives a slot back.
+func semaphore(n int) (acquire func(), release func(), tryAcquire func() bool) {
+	slots := make(chan struct{}, n)
+	acquire = func() {
+		slots <- struct{}{}
+	}
+	release = func() {
+		<-slots
+	}
+	tryAcquire = func() bool {
+		select {
+		case slots <- struct{}{}:
+			return true
+		default:
+			return false
+		}
+	}
+	return acquire, release, tryAcquire
+}
+
+// fibonacci returns a closure yielding 0, 1, 1, 2, 3, 5, ... on
+// successive calls.
+func fibonacci() func() int {
+	a, b := 0, 1
+	return func() int {
+		n := a
+		a, b = b, a+b
+		return n
+	}
+}
+
+// sequenceGen returns a closure yielding items in order and then starting
+// over from the first, e.g. to stub a flaky dependency in tests. It
+// panics if items is empty.
+func sequenceGen[T any](items ...T) func() T {
+	if len(items) == 0 {
+		panic("sequenceGen: no items")
+	}
+	i := 0
+	return func() T {
+		v := items[i]
+		i = (i + 1) % len(items)
+		return v
+	}
+}
+
+// recentLogs returns closures over a ring buffer holding the last
+// capacity lines: log appends a line, overwriting the oldest once full,
+// and dump returns the buffered lines oldest first. Both are safe for
+// concurrent use.
+func recentLogs(capacity int) (log func(string), dump func() []string) {
+	var mu sync.Mutex
+	ring := make([]string, capacity)
+	next, size := 0, 0
+
+	log = func(line string) {
+		if capacity <= 0 {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		ring[next] = line
+		next = (next + 1) % capacity
+		if size < capacity {
+			size++
+		}
+	}
+	dump = func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		out := make([]string, 0, size)
+		start := (next - size + capacity) % max(capacity, 1)
+		for i := 0; i < size; i++ {
+			out = append(out, ring[(start+i)%capacity])
+		}
+		return out
+	}
+	return log, dump
+}
+
+// ema returns a closure that folds each sample into an exponential moving
+// average with smoothing factor alpha and returns the new average. The
+// first sample seeds the average. It panics unless 0 < alpha <= 1.
+func ema(alpha float64) func(float64) float64 {
+	if !(alpha > 0 && alpha <= 1) {
+		panic(fmt.Sprintf("ema: alpha %v outside (0, 1]", alpha))
+	}
+	var avg float64
+	seeded := false
+	return func(sample float64) float64 {
+		if !seeded {
+			avg = sample
+			seeded = true
+			return avg
+		}
+		avg = alpha*sample + (1-alpha)*avg
+		return avg
+	}
+}
+
+// history returns closures over an undo/redo timeline of states. do
+// records a new state and discards anything that could have been redone.
+// undo steps back and returns the state now current; redo steps forward
+// again. Both report false when there is nowhere to go.
+func history[T any]() (do func(state T), undo func() (T, bool), redo func() (T, bool)) {
+	var states []T
+	cur := -1 // index of the current state in states
+
+	do = func(state T) {
+		states = append(states[:cur+1], state)
+		cur++
+	}
+	undo = func() (T, bool) {
+		if cur <= 0 {
+			var zero T
+			return zero, false
+		}
+		cur--
+		return states[cur], true
+	}
+	redo = func() (T, bool) {
+		if cur+1 >= len(states) {
+			var zero T
+			return zero, false
+		}
+		cur++
+		return states[cur], true
+	}
+	return do, undo, redo
+}
+
+// coalescingCounter returns an inc closure that counts events and hands
+// the accumulated count to flush (then resets it) at most once every
+// flushEvery, measured against clock. Flushing happens inside inc, so a
+// count left over when events stop is only delivered by stop, which
+// flushes any remainder and makes later incs no-ops.
+func coalescingCounter(flushEvery time.Duration, flush func(count int), clock Clock) (inc func(), stop func()) {
+	var mu sync.Mutex
+	count := 0
+	stopped := false
+	last := clock.Now()
+
+	inc = func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if stopped {
+			return
+		}
+		count++
+		if now := clock.Now(); now.Sub(last) >= flushEvery {
+			flush(count)
+			count = 0
+			last = now
+		}
+	}
+	stop = func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if stopped {
+			return
+		}
+		stopped = true
+		if count > 0 {
+			flush(count)
+			count = 0
+		}
+	}
+	return inc, stop
+}
+
+// fanIn drains each source closure on its own goroutine and merges the
+// values into the returned channel. A source signals it is exhausted by
+// returning false as its second result. The channel is closed once every
+// source is exhausted or ctx is cancelled, whichever comes first.
+func fanIn[T any](ctx context.Context, sources ...func() (T, bool)) <-chan T {
+	out := make(chan T)
+	var wg sync.WaitGroup
+	for _, src := range sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				v, more := src()
+				if !more {
+					return
+				}
+				select {
+				case out <- v:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
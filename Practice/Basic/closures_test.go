@@ -0,0 +1,946 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/constraints"
+)
+
+func TestConcurrentAdderSequential(t *testing.T) {
+	add, ref := concurrentAdder(), adder()
+	for _, x := range []int{1, 2, -3, 10, 0} {
+		if got, want := add(x), ref(x); got != want {
+			t.Fatalf("add(%d) = %d, want %d", x, got, want)
+		}
+	}
+}
+
+func TestConcurrentAdderParallel(t *testing.T) {
+	const goroutines, value = 100, 7
+	add := concurrentAdder()
+	var wg sync.WaitGroup
+	for range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			add(value)
+		}()
+	}
+	wg.Wait()
+	if got, want := add(0), goroutines*value; got != want {
+		t.Errorf("final sum = %d, want %d", got, want)
+	}
+}
+
+func TestAccumulator(t *testing.T) {
+	t.Run("int", func(t *testing.T) {
+		testAccumulator(t, []int{1, 2, 3, -4}, []int{1, 3, 6, 2})
+	})
+	t.Run("int64", func(t *testing.T) {
+		testAccumulator(t, []int64{1 << 40, 1 << 40, -1}, []int64{1 << 40, 1 << 41, 1<<41 - 1})
+	})
+	t.Run("float64", func(t *testing.T) {
+		testAccumulator(t, []float64{0.5, 0.25, 1.25}, []float64{0.5, 0.75, 2})
+	})
+}
+
+func testAccumulator[T constraints.Integer | constraints.Float](t *testing.T, in, want []T) {
+	t.Helper()
+	acc := accumulator[T]()
+	for i, x := range in {
+		if got := acc(x); got != want[i] {
+			t.Errorf("call %d: acc(%v) = %v, want %v", i, x, got, want[i])
+		}
+	}
+}
+
+func TestCounterReset(t *testing.T) {
+	inc, reset := counter()
+	steps := []struct {
+		reset bool
+		want  int
+	}{
+		{want: 1},
+		{want: 2},
+		{want: 3},
+		{reset: true, want: 1},
+		{want: 2},
+		{reset: true, want: 1},
+	}
+	for i, step := range steps {
+		if step.reset {
+			reset()
+		}
+		if got := inc(); got != step.want {
+			t.Errorf("step %d: inc() = %d, want %d", i, got, step.want)
+		}
+	}
+}
+
+func TestMultiplier(t *testing.T) {
+	tests := []struct {
+		factor, x, want int
+	}{
+		{3, 4, 12},
+		{3, -2, -6},
+		{0, 7, 0},
+	}
+	for _, tt := range tests {
+		m := multiplier(tt.factor)
+		if got := m(tt.x); got != tt.want {
+			t.Errorf("multiplier(%d)(%d) = %d, want %d", tt.factor, tt.x, got, tt.want)
+		}
+		// The captured factor never changes between calls.
+		if got := m(tt.x); got != tt.want {
+			t.Errorf("second multiplier(%d)(%d) = %d, want %d", tt.factor, tt.x, got, tt.want)
+		}
+	}
+}
+
+func TestRunningProduct(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []int
+		want []int
+	}{
+		{"grows", []int{2, 3, 4}, []int{2, 6, 24}},
+		{"zero collapses", []int{5, 0, 7}, []int{5, 0, 0}},
+		{"negative", []int{-1, 2}, []int{-1, -2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := runningProduct()
+			for i, x := range tt.in {
+				if got := p(x); got != tt.want[i] {
+					t.Errorf("call %d: p(%d) = %d, want %d", i, x, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMemoize(t *testing.T) {
+	calls := map[int]int{}
+	square := memoize(func(x int) int {
+		calls[x]++
+		return x * x
+	})
+	for _, x := range []int{2, 3, 2, 2, 3, -2} {
+		if got := square(x); got != x*x {
+			t.Errorf("square(%d) = %d, want %d", x, got, x*x)
+		}
+	}
+	for x, n := range calls {
+		if n != 1 {
+			t.Errorf("fn(%d) called %d times, want 1", x, n)
+		}
+	}
+	if len(calls) != 3 {
+		t.Errorf("fn called for %d distinct inputs, want 3", len(calls))
+	}
+}
+
+func TestOnce(t *testing.T) {
+	runs := 0
+	f := once(func() { runs++ })
+	for range 5 {
+		f()
+	}
+	if runs != 1 {
+		t.Errorf("fn ran %d times, want 1", runs)
+	}
+}
+
+func TestOnceSafeConcurrent(t *testing.T) {
+	var runs atomic.Int32
+	f := onceSafe(func() { runs.Add(1) })
+	var wg sync.WaitGroup
+	for range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f()
+		}()
+	}
+	wg.Wait()
+	if got := runs.Load(); got != 1 {
+		t.Errorf("fn ran %d times, want 1", got)
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	const d = 50 * time.Millisecond
+	var runs atomic.Int32
+	f := debounce(d, func() { runs.Add(1) })
+	for range 5 {
+		f()
+		time.Sleep(d / 10)
+	}
+	if got := runs.Load(); got != 0 {
+		t.Fatalf("fn ran %d times during the burst, want 0", got)
+	}
+	time.Sleep(3 * d)
+	if got := runs.Load(); got != 1 {
+		t.Errorf("fn ran %d times after the quiet period, want 1", got)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	clock := newFakeClock()
+	runs := 0
+	f := throttleClock(clock, 100*time.Millisecond, func() { runs++ })
+
+	// A tight loop of calls 10ms apart over 350ms fires at 0, 100, 200
+	// and 300ms.
+	for range 35 {
+		f()
+		clock.Advance(10 * time.Millisecond)
+	}
+	if runs != 4 {
+		t.Errorf("fn ran %d times, want 4", runs)
+	}
+}
+
+func TestThrottleRealClock(t *testing.T) {
+	runs := 0
+	f := throttle(time.Hour, func() { runs++ })
+	for range 100 {
+		f()
+	}
+	if runs != 1 {
+		t.Errorf("fn ran %d times, want 1", runs)
+	}
+}
+
+func TestCompose(t *testing.T) {
+	double, addTen := multiplier(2), func(x int) int { return x + 10 }
+	tests := []struct {
+		name string
+		fns  []func(int) int
+		in   int
+		want int
+	}{
+		{"identity", nil, 7, 7},
+		{"single", []func(int) int{double}, 7, 14},
+		{"left to right", []func(int) int{addTen, double}, 1, 22},
+		{"other order", []func(int) int{double, addTen}, 1, 12},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compose(tt.fns...)(tt.in); got != tt.want {
+				t.Errorf("compose(...)(%d) = %d, want %d", tt.in, got, tt.want)
+			}
+		})
+	}
+
+	// A stateful adder keeps its running total across composed calls.
+	sum := compose(adder(), double)
+	if got := sum(1); got != 2 {
+		t.Errorf("first call = %d, want 2", got)
+	}
+	if got := sum(2); got != 6 {
+		t.Errorf("second call = %d, want 6", got)
+	}
+}
+
+func TestCacheWithTTL(t *testing.T) {
+	calls := 0
+	upper := cacheWithTTL(time.Hour, func(s string) string {
+		calls++
+		return strings.ToUpper(s)
+	})
+	for range 3 {
+		if got := upper("go"); got != "GO" {
+			t.Fatalf("upper(go) = %q, want GO", got)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("fn called %d times before expiry, want 1", calls)
+	}
+}
+
+func TestCacheWithTTLClock(t *testing.T) {
+	const ttl = time.Minute
+	clock := newFakeClock()
+	calls := 0
+	lookup := cacheWithTTLClock(clock, ttl, func(s string) string {
+		calls++
+		return s + strconv.Itoa(calls)
+	})
+
+	steps := []struct {
+		advance time.Duration
+		key     string
+		want    string
+	}{
+		{0, "a", "a1"},
+		{ttl - time.Nanosecond, "a", "a1"},
+		{0, "b", "b2"},
+		{time.Nanosecond, "a", "a3"},
+		{0, "a", "a3"},
+		{ttl, "b", "b4"},
+	}
+	for i, step := range steps {
+		clock.Advance(step.advance)
+		if got := lookup(step.key); got != step.want {
+			t.Errorf("step %d: lookup(%q) = %q, want %q", i, step.key, got, step.want)
+		}
+	}
+}
+
+func TestStateMachine(t *testing.T) {
+	current, transition := stateMachine("draft", map[string][]string{
+		"draft":     {"review"},
+		"review":    {"draft", "published"},
+		"published": {},
+	})
+	steps := []struct {
+		to      string
+		wantErr bool
+		want    string
+	}{
+		{"review", false, "review"},
+		{"draft", false, "draft"},
+		{"published", true, "draft"},
+		{"review", false, "review"},
+		{"published", false, "published"},
+		{"draft", true, "published"},
+	}
+	for i, step := range steps {
+		err := transition(step.to)
+		if (err != nil) != step.wantErr {
+			t.Errorf("step %d: transition(%q) = %v, want error %v", i, step.to, err, step.wantErr)
+		}
+		if got := current(); got != step.want {
+			t.Errorf("step %d: current() = %q, want %q", i, got, step.want)
+		}
+	}
+}
+
+func TestIDGenerator(t *testing.T) {
+	const goroutines, perGoroutine = 50, 20
+	next := idGenerator("req")
+	ids := make(chan string, goroutines*perGoroutine)
+	var wg sync.WaitGroup
+	for range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range perGoroutine {
+				ids <- next()
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[string]bool)
+	for id := range ids {
+		n, ok := strings.CutPrefix(id, "req-")
+		if _, err := strconv.Atoi(n); !ok || err != nil {
+			t.Errorf("id %q is not req-<n>", id)
+		}
+		if seen[id] {
+			t.Errorf("duplicate id %q", id)
+		}
+		seen[id] = true
+	}
+	if len(seen) != goroutines*perGoroutine {
+		t.Errorf("got %d unique ids, want %d", len(seen), goroutines*perGoroutine)
+	}
+	if got := next(); got != "req-1001" {
+		t.Errorf("next() = %q, want req-1001", got)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	const base, max = 100 * time.Millisecond, time.Second
+	next, reset := backoff(base, max)
+	want := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, w := range want {
+		if got := next(); got != w*time.Millisecond {
+			t.Errorf("call %d: next() = %v, want %v", i, got, w*time.Millisecond)
+		}
+	}
+	reset()
+	if got := next(); got != base {
+		t.Errorf("next() after reset = %v, want %v", got, base)
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	const base, max = 10 * time.Millisecond, 80 * time.Millisecond
+	next := backoffJitter(base, max, rand.New(rand.NewPCG(1, 2)))
+	seen := make(map[time.Duration]bool)
+	for i := range 50 {
+		ceiling := min(base<<i, max)
+		if i > 10 {
+			ceiling = max
+		}
+		d := next()
+		if d < 0 || d > ceiling {
+			t.Errorf("call %d: delay %v outside [0, %v]", i, d, ceiling)
+		}
+		seen[d] = true
+	}
+	if len(seen) < 10 {
+		t.Errorf("only %d distinct delays in 50 calls, want jitter", len(seen))
+	}
+
+	// The same seed gives the same sequence.
+	a := backoffJitter(base, max, rand.New(rand.NewPCG(7, 7)))
+	b := backoffJitter(base, max, rand.New(rand.NewPCG(7, 7)))
+	for i := range 10 {
+		if da, db := a(), b(); da != db {
+			t.Errorf("call %d: %v != %v with the same seed", i, da, db)
+		}
+	}
+}
+
+func TestSingleFlight(t *testing.T) {
+	do := singleFlight[int]()
+	var runs, started atomic.Int32
+	release := make(chan struct{})
+	fn := func() (int, error) {
+		runs.Add(1)
+		<-release
+		return 42, nil
+	}
+
+	const n = 100
+	results := make([]int, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			started.Add(1)
+			v, err := do("key", fn)
+			if err != nil {
+				t.Errorf("err = %v, want nil", err)
+			}
+			results[i] = v
+		}()
+	}
+	for started.Load() < n {
+		time.Sleep(time.Millisecond)
+	}
+	// Give the stragglers time to reach the wait on the in-flight call.
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := runs.Load(); got != 1 {
+		t.Errorf("fn ran %d times, want 1", got)
+	}
+	for i, v := range results {
+		if v != 42 {
+			t.Errorf("results[%d] = %d, want 42", i, v)
+		}
+	}
+
+	// The key was forgotten, so the next call runs fn again.
+	if _, err := do("key", func() (int, error) { runs.Add(1); return 0, nil }); err != nil {
+		t.Fatal(err)
+	}
+	if got := runs.Load(); got != 2 {
+		t.Errorf("fn ran %d times after the flight finished, want 2", got)
+	}
+}
+
+func TestSingleFlightError(t *testing.T) {
+	do := singleFlight[string]()
+	want := errors.New("boom")
+	if _, err := do("a", func() (string, error) { return "", want }); !errors.Is(err, want) {
+		t.Errorf("err = %v, want %v", err, want)
+	}
+	// Different keys do not share a flight.
+	got, err := do("b", func() (string, error) { return "b", nil })
+	if err != nil || got != "b" {
+		t.Errorf("do(b) = %q, %v, want \"b\", nil", got, err)
+	}
+}
+
+func TestBatcher(t *testing.T) {
+	tests := []struct {
+		name  string
+		size  int
+		items int
+		want  [][]int
+	}{
+		{"exact multiple", 2, 4, [][]int{{0, 1}, {2, 3}}},
+		{"partial final batch", 3, 5, [][]int{{0, 1, 2}, {3, 4}}},
+		{"fewer than size", 4, 2, [][]int{{0, 1}}},
+		{"empty", 3, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got [][]int
+			add, closeFn := batcher(tt.size, func(b []int) { got = append(got, b) })
+			for i := range tt.items {
+				add(i)
+			}
+			closeFn()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("batches = %v, want %v", got, tt.want)
+			}
+			closeFn()
+			if len(got) != len(tt.want) {
+				t.Errorf("second close flushed again: %v", got)
+			}
+		})
+	}
+}
+
+func TestBatcherConcurrent(t *testing.T) {
+	const size, n = 7, 1000
+	var batches [][]int
+	add, closeFn := batcher(size, func(b []int) { batches = append(batches, b) })
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			add(i)
+		}()
+	}
+	wg.Wait()
+	closeFn()
+
+	var all []int
+	for i, b := range batches {
+		if i < len(batches)-1 && len(b) != size {
+			t.Errorf("batch %d has %d items, want %d", i, len(b), size)
+		}
+		all = append(all, b...)
+	}
+	slices.Sort(all)
+	if len(all) != n {
+		t.Fatalf("flushed %d items, want %d", len(all), n)
+	}
+	for i, v := range all {
+		if v != i {
+			t.Fatalf("item %d = %d, want %d (lost or duplicated)", i, v, i)
+		}
+	}
+}
+
+func TestRollingCounter(t *testing.T) {
+	clock := newFakeClock()
+	hit := rollingCounter(time.Minute, clock)
+	steps := []struct {
+		advance time.Duration
+		want    int
+	}{
+		{0, 1},
+		{10 * time.Second, 2},
+		{20 * time.Second, 3},
+		{30 * time.Second, 3},              // t=60s: the hit at t=0 falls out
+		{5 * time.Second, 4},               // t=65s
+		{2 * time.Minute, 1},               // everything older pruned
+		{time.Minute - time.Nanosecond, 2}, // just inside the window
+		{time.Nanosecond, 2},               // hit at t=185s drops, one added
+	}
+	for i, s := range steps {
+		clock.Advance(s.advance)
+		if got := hit(); got != s.want {
+			t.Errorf("step %d: hit() = %d, want %d", i, got, s.want)
+		}
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	clock := newFakeClock()
+	take := tokenBucket(3, time.Second, clock)
+	steps := []struct {
+		name    string
+		advance time.Duration
+		want    []bool
+	}{
+		{"starts full then depletes", 0, []bool{true, true, true, false}},
+		{"one refill", time.Second, []bool{true, false}},
+		{"partial interval grants nothing", 500 * time.Millisecond, []bool{false}},
+		{"partial intervals accumulate", 500 * time.Millisecond, []bool{true, false}},
+		{"refill capped at capacity", 10 * time.Second, []bool{true, true, true, false}},
+	}
+	for _, s := range steps {
+		clock.Advance(s.advance)
+		for i, want := range s.want {
+			if got := take(); got != want {
+				t.Errorf("%s: take #%d = %v, want %v", s.name, i+1, got, want)
+			}
+		}
+	}
+}
+
+func TestTokenBucketFullDoesNotBank(t *testing.T) {
+	clock := newFakeClock()
+	take := tokenBucket(1, time.Second, clock)
+	// Idling with a full bucket must not earn credit for later.
+	clock.Advance(5 * time.Second)
+	if !take() {
+		t.Fatal("first take failed")
+	}
+	if take() {
+		t.Error("second take succeeded straight away, want false")
+	}
+	clock.Advance(time.Second)
+	if !take() {
+		t.Error("take after one refill interval failed")
+	}
+}
+
+func TestSemaphoreTryAcquire(t *testing.T) {
+	acquire, release, tryAcquire := semaphore(2)
+	acquire()
+	if !tryAcquire() {
+		t.Fatal("tryAcquire failed with a free slot")
+	}
+	if tryAcquire() {
+		t.Fatal("tryAcquire succeeded with no free slots")
+	}
+	release()
+	if !tryAcquire() {
+		t.Error("tryAcquire failed after release")
+	}
+}
+
+func TestSemaphoreCapacity(t *testing.T) {
+	const n = 3
+	acquire, release, _ := semaphore(n)
+	var inside, peak atomic.Int32
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			acquire()
+			defer release()
+			cur := inside.Add(1)
+			for {
+				p := peak.Load()
+				if cur <= p || peak.CompareAndSwap(p, cur) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			inside.Add(-1)
+		}()
+	}
+	wg.Wait()
+	if got := peak.Load(); got > n {
+		t.Errorf("peak concurrency = %d, want at most %d", got, n)
+	}
+}
+
+func TestFibonacci(t *testing.T) {
+	next := fibonacci()
+	want := []int{0, 1, 1, 2, 3, 5, 8, 13, 21, 34}
+	for i, w := range want {
+		if got := next(); got != w {
+			t.Errorf("call %d = %d, want %d", i+1, got, w)
+		}
+	}
+	// Each closure keeps its own state.
+	if got := fibonacci()(); got != 0 {
+		t.Errorf("fresh generator started at %d, want 0", got)
+	}
+}
+
+func TestSequenceGen(t *testing.T) {
+	tests := []struct {
+		name  string
+		items []string
+		calls int
+		want  []string
+	}{
+		{"single item repeats", []string{"x"}, 3, []string{"x", "x", "x"}},
+		{"cycles back", []string{"a", "b", "c"}, 7, []string{"a", "b", "c", "a", "b", "c", "a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := sequenceGen(tt.items...)
+			var got []string
+			for range tt.calls {
+				got = append(got, next())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("sequence = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSequenceGenEmptyPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("sequenceGen() with no items did not panic")
+		}
+	}()
+	sequenceGen[int]()
+}
+
+func TestRecentLogs(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		lines    int
+		want     []string
+	}{
+		{"empty", 3, 0, []string{}},
+		{"partly full", 3, 2, []string{"l0", "l1"}},
+		{"exactly full", 3, 3, []string{"l0", "l1", "l2"}},
+		{"wraps around", 3, 5, []string{"l2", "l3", "l4"}},
+		{"wraps several times", 2, 7, []string{"l5", "l6"}},
+		{"zero capacity keeps nothing", 0, 3, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log, dump := recentLogs(tt.capacity)
+			for i := range tt.lines {
+				log("l" + strconv.Itoa(i))
+			}
+			if got := dump(); !slices.Equal(got, tt.want) {
+				t.Errorf("dump() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecentLogsConcurrent(t *testing.T) {
+	const capacity = 50
+	log, dump := recentLogs(capacity)
+	var wg sync.WaitGroup
+	for g := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 100 {
+				log(strconv.Itoa(g*100 + i))
+				dump()
+			}
+		}()
+	}
+	wg.Wait()
+	got := dump()
+	if len(got) != capacity {
+		t.Fatalf("dump() has %d lines, want %d", len(got), capacity)
+	}
+	// Lines from each goroutine stay in the order it logged them.
+	last := make(map[int]int)
+	for _, line := range got {
+		n, _ := strconv.Atoi(line)
+		if prev, ok := last[n/100]; ok && n <= prev {
+			t.Errorf("line %d after %d from the same goroutine", n, prev)
+		}
+		last[n/100] = n
+	}
+}
+
+func TestEMA(t *testing.T) {
+	tests := []struct {
+		name    string
+		alpha   float64
+		samples []float64
+		want    []float64
+	}{
+		{"half", 0.5, []float64{10, 20, 30, 10}, []float64{10, 15, 22.5, 16.25}},
+		{"slow decay", 0.2, []float64{100, 0, 0}, []float64{100, 80, 64}},
+		{"alpha one tracks samples", 1, []float64{3, -1, 7}, []float64{3, -1, 7}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := ema(tt.alpha)
+			for i, s := range tt.samples {
+				if got := next(s); math.Abs(got-tt.want[i]) > 1e-9 {
+					t.Errorf("sample %d (%v): ema = %v, want %v", i, s, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEMAInvalidAlpha(t *testing.T) {
+	for _, alpha := range []float64{0, -0.5, 1.5, math.NaN()} {
+		t.Run(strconv.FormatFloat(alpha, 'g', -1, 64), func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("ema(%v) did not panic", alpha)
+				}
+			}()
+			ema(alpha)
+		})
+	}
+}
+
+func TestHistory(t *testing.T) {
+	do, undo, redo := history[string]()
+	type step struct {
+		op     string // "do", "undo" or "redo"
+		state  string // for do; the expected state otherwise
+		wantOK bool
+	}
+	steps := []step{
+		{"undo", "", false}, // nothing recorded
+		{"redo", "", false},
+		{"do", "a", true},
+		{"undo", "", false}, // a is the first state
+		{"do", "b", true},
+		{"do", "c", true},
+		{"undo", "b", true},
+		{"undo", "a", true},
+		{"undo", "", false},
+		{"redo", "b", true},
+		{"redo", "c", true},
+		{"redo", "", false},
+		{"undo", "b", true},
+		{"do", "d", true}, // branches off b, dropping c
+		{"redo", "", false},
+		{"undo", "b", true},
+		{"redo", "d", true},
+	}
+	for i, s := range steps {
+		var got string
+		var ok bool
+		switch s.op {
+		case "do":
+			do(s.state)
+			continue
+		case "undo":
+			got, ok = undo()
+		case "redo":
+			got, ok = redo()
+		}
+		if got != s.state || ok != s.wantOK {
+			t.Errorf("step %d %s() = %q, %v, want %q, %v", i, s.op, got, ok, s.state, s.wantOK)
+		}
+	}
+}
+
+func TestCoalescingCounter(t *testing.T) {
+	clock := newFakeClock()
+	var flushed []int
+	inc, stop := coalescingCounter(time.Second, func(n int) { flushed = append(flushed, n) }, clock)
+
+	steps := []struct {
+		advance time.Duration
+		incs    int
+		want    []int
+	}{
+		{0, 5, nil},                           // within the first interval
+		{time.Second, 1, []int{6}},            // interval passed: 5 + this one
+		{500 * time.Millisecond, 3, []int{6}}, // too soon
+		{500 * time.Millisecond, 1, []int{6, 4}},
+		{3 * time.Second, 2, []int{6, 4, 1}}, // flushes on the first inc; the second is pending
+	}
+	for i, s := range steps {
+		clock.Advance(s.advance)
+		for range s.incs {
+			inc()
+		}
+		if !slices.Equal(flushed, s.want) {
+			t.Errorf("step %d: flushed %v, want %v", i, flushed, s.want)
+		}
+	}
+
+	// stop delivers the pending partial count.
+	stop()
+	if want := []int{6, 4, 1, 1}; !slices.Equal(flushed, want) {
+		t.Errorf("after stop: flushed %v, want %v", flushed, want)
+	}
+}
+
+func TestCoalescingCounterStopFlushesRemainder(t *testing.T) {
+	clock := newFakeClock()
+	var flushed []int
+	inc, stop := coalescingCounter(time.Minute, func(n int) { flushed = append(flushed, n) }, clock)
+	for range 7 {
+		inc()
+	}
+	stop()
+	stop()
+	inc() // ignored after stop
+	clock.Advance(time.Hour)
+	inc()
+	if want := []int{7}; !slices.Equal(flushed, want) {
+		t.Errorf("flushed %v, want %v", flushed, want)
+	}
+}
+
+// countTo returns a fanIn source yielding from+1..from+n, then exhausted.
+func countTo(from, n int) func() (int, bool) {
+	i := from
+	return func() (int, bool) {
+		if i == from+n {
+			return 0, false
+		}
+		i++
+		return i, true
+	}
+}
+
+func TestFanIn(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources []func() (int, bool)
+		want    []int
+	}{
+		{"no sources", nil, nil},
+		{"one source", []func() (int, bool){countTo(0, 3)}, []int{1, 2, 3}},
+		{"merges two", []func() (int, bool){countTo(0, 3), countTo(10, 2)}, []int{1, 2, 3, 11, 12}},
+		{"empty source", []func() (int, bool){countTo(0, 0), countTo(10, 1)}, []int{11}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int
+			for v := range fanIn(context.Background(), tt.sources...) {
+				got = append(got, v)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("fanIn() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFanInCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls atomic.Int64
+	endless := func() (int, bool) {
+		return int(calls.Add(1)), true
+	}
+	out := fanIn(ctx, endless, endless)
+	for range 3 {
+		<-out
+	}
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		for range out {
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("channel not closed after cancel")
+	}
+	stopped := calls.Load()
+	time.Sleep(10 * time.Millisecond)
+	if n := calls.Load(); n != stopped {
+		t.Errorf("sources called %d more times after the channel closed", n-stopped)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+// Map returns f applied to each element of s. A nil or empty s gives an
+// empty, non-nil result.
+func Map[T, U any](s []T, f func(T) U) []U {
+	out := make([]U, 0, len(s))
+	for _, v := range s {
+		out = append(out, f(v))
+	}
+	return out
+}
+
+// Filter returns the elements of s for which pred is true, in order.
+func Filter[T any](s []T, pred func(T) bool) []T {
+	out := make([]T, 0, len(s))
+	for _, v := range s {
+		if pred(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Reduce folds s into a single value, starting from init and combining
+// each element with f. An empty s returns init.
+func Reduce[T, U any](s []T, init U, f func(U, T) U) U {
+	acc := init
+	for _, v := range s {
+		acc = f(acc, v)
+	}
+	return acc
+}
+
+// Pipe2 returns a function applying f then g. Go's type parameters can't
+// express a variadic chain of differently typed functions, so Pipe3 and
+// Pipe4 cover longer pipelines.
+func Pipe2[A, B, C any](f func(A) B, g func(B) C) func(A) C {
+	return func(a A) C {
+		return g(f(a))
+	}
+}
+
+// Pipe3 returns a function applying f, g and h in turn.
+func Pipe3[A, B, C, D any](f func(A) B, g func(B) C, h func(C) D) func(A) D {
+	return Pipe2(Pipe2(f, g), h)
+}
+
+// Pipe4 returns a function applying f, g, h and i in turn.
+func Pipe4[A, B, C, D, E any](f func(A) B, g func(B) C, h func(C) D, i func(D) E) func(A) E {
+	return Pipe2(Pipe3(f, g, h), i)
+}
+
+// filterBuilder returns an and func that accumulates predicates and a
+// build func that returns a predicate true only when all of them are.
+// With no predicates the result accepts everything. Predicates added
+// after build are not seen by the predicate it already returned.
+func filterBuilder[T any]() (and func(pred func(T) bool), build func() func(T) bool) {
+	var preds []func(T) bool
+	and = func(pred func(T) bool) {
+		preds = append(preds, pred)
+	}
+	build = func() func(T) bool {
+		snapshot := append([]func(T) bool(nil), preds...)
+		return func(v T) bool {
+			for _, p := range snapshot {
+				if !p(v) {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return and, build
+}
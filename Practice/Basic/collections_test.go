@@ -0,0 +1,153 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestMap(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []int
+		want []string
+	}{
+		{"nil", nil, []string{}},
+		{"empty", []int{}, []string{}},
+		{"values", []int{1, 2, 3}, []string{"1", "2", "3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Map(tt.in, strconv.Itoa)
+			if got == nil || !slices.Equal(got, tt.want) {
+				t.Errorf("Map(%v) = %#v, want %#v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilter(t *testing.T) {
+	even := func(x int) bool { return x%2 == 0 }
+	tests := []struct {
+		name string
+		in   []int
+		want []int
+	}{
+		{"nil", nil, []int{}},
+		{"none match", []int{1, 3}, []int{}},
+		{"some match", []int{1, 2, 3, 4}, []int{2, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Filter(tt.in, even); !slices.Equal(got, tt.want) {
+				t.Errorf("Filter(%v) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReduce(t *testing.T) {
+	sum := func(acc, x int) int { return acc + x }
+	tests := []struct {
+		name string
+		in   []int
+		init int
+		want int
+	}{
+		{"nil returns init", nil, 7, 7},
+		{"sum", []int{1, 2, 3}, 0, 6},
+		{"sum with init", []int{1, 2, 3}, 10, 16},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Reduce(tt.in, tt.init, sum); got != tt.want {
+				t.Errorf("Reduce(%v, %d) = %d, want %d", tt.in, tt.init, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMapFilterReduce(t *testing.T) {
+	// Total length of the decimal forms of the even squares of 1..10.
+	nums := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	squares := Map(nums, func(x int) int { return x * x })
+	even := Filter(squares, func(x int) bool { return x%2 == 0 })
+	total := Reduce(even, 0, func(acc, x int) int { return acc + len(strconv.Itoa(x)) })
+
+	// 4, 16, 36, 64, 100
+	if total != 1+2+2+2+3 {
+		t.Errorf("total = %d, want 10", total)
+	}
+}
+
+func TestPipe(t *testing.T) {
+	itoa := strconv.Itoa
+	length := func(s string) int { return len(s) }
+	double := func(x int) int { return 2 * x }
+	exclaim := func(s string) string { return s + "!" }
+
+	tests := []struct {
+		name string
+		fn   func(int) int
+		in   int
+		want int
+	}{
+		{"Pipe2 int->string->int", Pipe2(itoa, length), 12345, 5},
+		{"Pipe3", Pipe3(double, itoa, length), 500, 4},
+		{"Pipe4", Pipe4(itoa, exclaim, exclaim, length), 7, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.fn(tt.in); got != tt.want {
+				t.Errorf("f(%d) = %d, want %d", tt.in, got, tt.want)
+			}
+		})
+	}
+
+	atoi := func(s string) int {
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+	if got := Pipe2(atoi, itoa)("042"); got != "42" {
+		t.Errorf("Pipe2(atoi, itoa)(042) = %q, want 42", got)
+	}
+}
+
+func TestFilterBuilder(t *testing.T) {
+	positive := func(x int) bool { return x > 0 }
+	even := func(x int) bool { return x%2 == 0 }
+	tests := []struct {
+		name  string
+		preds []func(int) bool
+		in    []int
+		want  []int
+	}{
+		{"empty builder accepts all", nil, []int{-2, 0, 3}, []int{-2, 0, 3}},
+		{"one predicate", []func(int) bool{positive}, []int{-2, 0, 3, 4}, []int{3, 4}},
+		{"two predicates", []func(int) bool{positive, even}, []int{-2, 0, 3, 4, 6, 7}, []int{4, 6}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			and, build := filterBuilder[int]()
+			for _, p := range tt.preds {
+				and(p)
+			}
+			if got := Filter(tt.in, build()); !slices.Equal(got, tt.want) {
+				t.Errorf("Filter(%v) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("built predicate is a snapshot", func(t *testing.T) {
+		and, build := filterBuilder[int]()
+		and(positive)
+		pred := build()
+		and(even)
+		if !pred(3) {
+			t.Error("predicate built before and(even) rejected 3")
+		}
+		if build()(3) {
+			t.Error("predicate built after and(even) accepted 3")
+		}
+	})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sync/atomic"
+)
+
+// Config holds the settings that can be changed while the server runs by
+// editing the config file and sending SIGHUP.
+type Config struct {
+	LogLevel       string   `json:"log_level"`
+	AllowedOrigins []string `json:"allowed_origins"`
+}
+
+// defaultConfig is used when no config file is given.
+func defaultConfig() *Config {
+	return &Config{LogLevel: "info"}
+}
+
+// currentConfig is the live configuration. Readers call Load on every
+// request so a reload takes effect without restarting.
+var currentConfig atomic.Pointer[Config]
+
+// configPath is the JSON file reloadConfig reads; set from the -config
+// flag. Empty means defaultConfig.
+var configPath string
+
+func init() {
+	currentConfig.Store(defaultConfig())
+}
+
+// loadConfig reads a JSON Config from path, filling unset fields from
+// defaultConfig. An empty path returns defaultConfig.
+func loadConfig(path string) (*Config, error) {
+	cfg := defaultConfig()
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+	if _, ok := logLevels[cfg.LogLevel]; !ok {
+		return nil, fmt.Errorf("config %s: unknown log_level %q", path, cfg.LogLevel)
+	}
+	return cfg, nil
+}
+
+// reloadConfig re-reads configPath and swaps it in as currentConfig. On
+// error the previous config stays in effect.
+func reloadConfig() error {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	currentConfig.Store(cfg)
+	return nil
+}
+
+// withConfiguredCORS is withCORS using currentConfig's AllowedOrigins,
+// so origins can be changed with a reload.
+func withConfiguredCORS() func(http.Handler) http.Handler {
+	return corsMiddleware(func(origin string) bool {
+		origins := currentConfig.Load().AllowedOrigins
+		return slices.Contains(origins, "*") || slices.Contains(origins, origin)
+	})
+}
+
+// logLevels orders the accepted LogLevel values; an entry is logged when
+// its level is at least the configured one.
+var logLevels = map[string]int{
+	"debug": 0,
+	"info":  1,
+	"warn":  2,
+	"error": 3,
+}
+
+// levelLogger drops entries below currentConfig's LogLevel. Warn and
+// Error go through logWarn and logError, so base needn't be leveled.
+type levelLogger struct {
+	base Logger
+}
+
+// configuredLogger wraps base so it honours the live LogLevel setting.
+func configuredLogger(base Logger) Logger {
+	return levelLogger{base: base}
+}
+
+// enabled reports whether entries at level pass the live LogLevel.
+func (l levelLogger) enabled(level string) bool {
+	return logLevels[level] >= logLevels[currentConfig.Load().LogLevel]
+}
+
+func (l levelLogger) Info(msg string, kv ...any) {
+	if l.enabled("info") {
+		l.base.Info(msg, kv...)
+	}
+}
+
+func (l levelLogger) Warn(msg string, kv ...any) {
+	if l.enabled("warn") {
+		logWarn(l.base, msg, kv...)
+	}
+}
+
+func (l levelLogger) Error(msg string, kv ...any) {
+	if l.enabled("error") {
+		logError(l.base, msg, kv...)
+	}
+}
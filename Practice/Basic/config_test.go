@@ -0,0 +1,177 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// useConfig swaps cfg in as currentConfig for the rest of the test.
+func useConfig(t *testing.T, cfg *Config) {
+	t.Helper()
+	prev := currentConfig.Load()
+	currentConfig.Store(cfg)
+	t.Cleanup(func() { currentConfig.Store(prev) })
+}
+
+// writeConfig writes body to a config file in dir and returns its path.
+func writeConfig(t *testing.T, dir, body string) string {
+	t.Helper()
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    *Config
+		wantErr string
+	}{
+		{"defaults fill unset fields", `{"allowed_origins": ["https://a.example"]}`, &Config{LogLevel: "info", AllowedOrigins: []string{"https://a.example"}}, ""},
+		{"every field", `{"log_level": "warn", "allowed_origins": ["*"]}`, &Config{LogLevel: "warn", AllowedOrigins: []string{"*"}}, ""},
+		{"unknown log_level", `{"log_level": "verbose"}`, nil, `unknown log_level "verbose"`},
+		{"bad JSON", `{"log_level":`, nil, "parse config"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadConfig(writeConfig(t, t.TempDir(), tt.body))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.LogLevel != tt.want.LogLevel || !slices.Equal(got.AllowedOrigins, tt.want.AllowedOrigins) {
+				t.Errorf("loadConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("empty path", func(t *testing.T) {
+		got, err := loadConfig("")
+		if err != nil || got.LogLevel != "info" {
+			t.Errorf("loadConfig(\"\") = %+v, %v, want defaults", got, err)
+		}
+	})
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := loadConfig(filepath.Join(t.TempDir(), "nope.json")); err == nil {
+			t.Error("err = nil, want an error")
+		}
+	})
+}
+
+func TestLevelLogger(t *testing.T) {
+	tests := []struct {
+		level string
+		want  []string
+	}{
+		{"debug", []string{"info", "warn", "error"}},
+		{"info", []string{"info", "warn", "error"}},
+		{"warn", []string{"warn", "error"}},
+		{"error", []string{"error"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.level, func(t *testing.T) {
+			useConfig(t, &Config{LogLevel: tt.level})
+			var base fakeLogger
+			l := configuredLogger(&base).(levelLogger)
+			l.Info("info")
+			l.Warn("warn")
+			l.Error("error")
+
+			var got []string
+			for _, c := range base.calls {
+				got = append(got, c.msg)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("logged %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLevelLoggerKeepsLevelField(t *testing.T) {
+	useConfig(t, &Config{LogLevel: "warn"})
+	var base fakeLogger
+	logWarn(configuredLogger(&base), "slow", "ms", 900)
+	if len(base.calls) != 1 {
+		t.Fatalf("got %d entries, want 1", len(base.calls))
+	}
+	if got := formatKV(base.calls[0].msg, base.calls[0].kv); got != "slow level=warn ms=900" {
+		t.Errorf("entry = %q, want %q", got, "slow level=warn ms=900")
+	}
+}
+
+func TestRunServerReloadsConfigOnSIGHUP(t *testing.T) {
+	dir := t.TempDir()
+	prevPath := configPath
+	configPath = writeConfig(t, dir, `{"log_level": "info", "allowed_origins": ["https://a.example"]}`)
+	t.Cleanup(func() { configPath = prevPath })
+	useConfig(t, currentConfig.Load())
+	if err := reloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := &logRecorder{}
+	logger := configuredLogger(rec)
+	handler := withConfiguredCORS()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.Info("request", "path", r.URL.Path)
+	}))
+	addr, done := startHeldServer(t, handler)
+
+	client := &http.Client{Transport: &http.Transport{}}
+	allowed := func(origin string) string {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, "http://"+addr+"/hello", nil)
+		req.Header.Set("Origin", origin)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.Header.Get("Access-Control-Allow-Origin")
+	}
+	if got := allowed("https://b.example"); got != "" {
+		t.Fatalf("b.example allowed before reload: %q", got)
+	}
+
+	writeConfig(t, dir, `{"log_level": "error", "allowed_origins": ["https://b.example"]}`)
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for allowed("https://b.example") != "https://b.example" {
+		if time.Now().After(deadline) {
+			t.Fatal("new allowed_origins never took effect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := allowed("https://a.example"); got != "" {
+		t.Errorf("a.example still allowed after reload: %q", got)
+	}
+
+	// The server kept running, and log_level=error now drops Info.
+	before := rec.String()
+	allowed("https://b.example")
+	if after := rec.String(); after != before {
+		t.Errorf("Info logged after reload to log_level=error: %q", strings.TrimPrefix(after, before))
+	}
+
+	client.CloseIdleConnections()
+	terminate(t)
+	if err := waitReturn(t, done); err != nil {
+		t.Errorf("runServer() = %v, want nil", err)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import "sync"
+
+type subscriber struct {
+	id      int
+	handler func(payload any)
+}
+
+// EventBus delivers published payloads to the handlers subscribed to an
+// event name. It is safe for concurrent use.
+type EventBus struct {
+	mu     sync.RWMutex
+	nextID int
+	subs   map[string][]subscriber
+}
+
+// NewEventBus returns an EventBus with no subscribers.
+func NewEventBus() *EventBus {
+	return &EventBus{subs: make(map[string][]subscriber)}
+}
+
+// Subscribe registers handler for event and returns a closure that
+// removes it again. Calling unsubscribe more than once is harmless.
+func (b *EventBus) Subscribe(event string, handler func(payload any)) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID++
+	id := b.nextID
+	b.subs[event] = append(b.subs[event], subscriber{id: id, handler: handler})
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		subs := b.subs[event]
+		for i, s := range subs {
+			if s.id == id {
+				b.subs[event] = append(subs[:i:i], subs[i+1:]...)
+				break
+			}
+		}
+		if len(b.subs[event]) == 0 {
+			delete(b.subs, event)
+		}
+	}
+}
+
+// Publish calls every handler subscribed to event, in subscription order.
+// Handlers run on the caller's goroutine, outside the bus lock, so they
+// may subscribe or unsubscribe themselves.
+func (b *EventBus) Publish(event string, payload any) {
+	b.mu.RLock()
+	subs := b.subs[event]
+	b.mu.RUnlock()
+	for _, s := range subs {
+		s.handler(payload)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestEventBus(t *testing.T) {
+	bus := NewEventBus()
+	var got []string
+	record := func(name string) func(any) {
+		return func(payload any) { got = append(got, name+":"+payload.(string)) }
+	}
+
+	unsubA := bus.Subscribe("saved", record("a"))
+	bus.Subscribe("saved", record("b"))
+	bus.Subscribe("deleted", record("c"))
+
+	bus.Publish("saved", "1")
+	bus.Publish("nobody-listens", "x")
+	unsubA()
+	unsubA()
+	bus.Publish("saved", "2")
+	bus.Publish("deleted", "3")
+
+	want := []string{"a:1", "b:1", "b:2", "c:3"}
+	if !slices.Equal(got, want) {
+		t.Errorf("deliveries = %v, want %v", got, want)
+	}
+}
+
+func TestEventBusUnsubscribeDuringPublish(t *testing.T) {
+	bus := NewEventBus()
+	calls := 0
+	var unsub func()
+	unsub = bus.Subscribe("tick", func(any) {
+		calls++
+		unsub()
+	})
+	bus.Publish("tick", nil)
+	bus.Publish("tick", nil)
+	if calls != 1 {
+		t.Errorf("handler ran %d times, want 1", calls)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// accessEvent summarises one request for withEventStream subscribers.
+type accessEvent struct {
+	Time     time.Time     `json:"time"`
+	Method   string        `json:"method"`
+	Path     string        `json:"path"`
+	Status   int           `json:"status"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// eventStreamBuffer is how many events a slow SSE client may fall behind
+// before new ones are dropped for it.
+const eventStreamBuffer = 16
+
+// withEventStream returns a middleware that publishes a summary of each
+// request, and a handler (to mount at e.g. /events) that streams those
+// summaries to connected clients as Server-Sent Events. A client is
+// unsubscribed as soon as its request context ends.
+func withEventStream() (func(http.Handler) http.Handler, http.Handler) {
+	const topic = "access"
+	bus := NewEventBus()
+
+	mw := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := newStatusRecorder(w)
+			next.ServeHTTP(rec, r)
+			bus.Publish(topic, accessEvent{
+				Time:     start,
+				Method:   r.Method,
+				Path:     r.URL.Path,
+				Status:   rec.status,
+				Duration: time.Since(start),
+			})
+		})
+	}
+
+	stream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		events := make(chan accessEvent, eventStreamBuffer)
+		unsubscribe := bus.Subscribe(topic, func(payload any) {
+			select {
+			case events <- payload.(accessEvent):
+			default:
+			}
+		})
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case e := <-events:
+				data, err := json.Marshal(e)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", topic, data)
+				flusher.Flush()
+			}
+		}
+	})
+
+	return mw, stream
+}
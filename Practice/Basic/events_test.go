@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithEventStream(t *testing.T) {
+	mw, stream := withEventStream()
+	streamDone := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		defer close(streamDone)
+		stream.ServeHTTP(w, r)
+	})
+	mux.Handle("/", mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events", nil)
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	// The subscription exists once the headers arrive.
+	hit, err := srv.Client().Get(srv.URL + "/brew?x=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hit.Body.Close()
+
+	lines := make(chan string)
+	go func() {
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+		close(lines)
+	}()
+	var event, data string
+	timeout := time.After(5 * time.Second)
+	for data == "" {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("stream closed before an event arrived")
+			}
+			if v, ok := strings.CutPrefix(line, "event: "); ok {
+				event = v
+			}
+			if v, ok := strings.CutPrefix(line, "data: "); ok {
+				data = v
+			}
+		case <-timeout:
+			t.Fatal("no event received")
+		}
+	}
+
+	if event != "access" {
+		t.Errorf("event = %q, want %q", event, "access")
+	}
+	var got accessEvent
+	if err := json.Unmarshal([]byte(data), &got); err != nil {
+		t.Fatalf("data %q: %v", data, err)
+	}
+	if got.Method != http.MethodGet || got.Path != "/brew" || got.Status != http.StatusTeapot {
+		t.Errorf("event = %+v, want GET /brew 418", got)
+	}
+
+	// Disconnecting ends the stream handler, which unsubscribes it.
+	cancel()
+	select {
+	case <-streamDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream handler still running after the client disconnected")
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// GeoInfo is what a geo/AS lookup knows about a client IP.
+type GeoInfo struct {
+	Country string
+	Region  string
+	ASN     int
+	ASOrg   string
+}
+
+type geoKey struct{}
+
+// withGeoTag resolves the client IP with lookup, stores the result in the
+// request context for GeoFromContext and logs the country through
+// LoggerFromContext. lookup is injected so no GeoIP database has to ship
+// with the example. If lookup fails the request is served untagged.
+func withGeoTag(lookup func(ip string) (GeoInfo, error)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := clientIP(r, false)
+			info, err := lookup(ip)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			LoggerFromContext(r.Context()).Info("Geo", "ip", ip, "country", info.Country)
+			ctx := context.WithValue(r.Context(), geoKey{}, info)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// GeoFromContext returns the GeoInfo stored by withGeoTag, if any.
+func GeoFromContext(ctx context.Context) (GeoInfo, bool) {
+	info, ok := ctx.Value(geoKey{}).(GeoInfo)
+	return info, ok
+}
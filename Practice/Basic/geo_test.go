@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWithGeoTag(t *testing.T) {
+	known := GeoInfo{Country: "NZ", Region: "Wellington", ASN: 64500, ASOrg: "Example Net"}
+	lookup := func(ip string) (GeoInfo, error) {
+		if ip == "198.51.100.7" {
+			return known, nil
+		}
+		return GeoInfo{}, errors.New("not in database")
+	}
+	tests := []struct {
+		name    string
+		remote  string
+		want    GeoInfo
+		wantOK  bool
+		wantLog string
+	}{
+		{"known IP", "198.51.100.7:4000", known, true, "Geo method=GET path=/ ip=198.51.100.7 country=NZ"},
+		{"lookup error still serves", "203.0.113.9:4000", GeoInfo{}, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := &logRecorder{}
+			var got GeoInfo
+			var ok bool
+			h := Chain(withContextLogger(logs), withGeoTag(lookup))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, ok = GeoFromContext(r.Context())
+				w.Write([]byte("served"))
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remote
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK || rec.Body.String() != "served" {
+				t.Errorf("response = %d %q, want 200 \"served\"", rec.Code, rec.Body)
+			}
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("GeoFromContext = %+v, %v, want %+v, %v", got, ok, tt.want, tt.wantOK)
+			}
+			if logged := logs.String(); tt.wantLog == "" && logged != "" || !strings.Contains(logged, tt.wantLog) {
+				t.Errorf("logs = %q, want %q", logged, tt.wantLog)
+			}
+		})
+	}
+}
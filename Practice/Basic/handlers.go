@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// healthHandler answers GET requests with 200 and {"status":"ok"}.
+func healthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+}
+
+// writeJSON encodes v as the response body with the given status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// helloHandler greets the caller, as JSON when the Accept header asks for
+// application/json and as plain text otherwise.
+func helloHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if wantsJSON(r) {
+			writeJSON(w, http.StatusOK, map[string]string{"message": "Hello, World!"})
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Hello, World!"))
+	})
+}
+
+// wantsJSON reports whether the Accept header lists application/json.
+func wantsJSON(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(part, ";")
+		if strings.TrimSpace(mediaType) == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+// readiness returns a readiness flag that starts out ready. Once
+// setNotReady is called, ready reports false for good.
+func readiness() (ready func() bool, setNotReady func()) {
+	var notReady atomic.Bool
+	ready = func() bool {
+		return !notReady.Load()
+	}
+	setNotReady = func() {
+		notReady.Store(true)
+	}
+	return ready, setNotReady
+}
+
+// readyHandler answers 200 while ready reports true and 503 afterwards,
+// so load balancers stop routing to a server that is shutting down.
+func readyHandler(ready func() bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ready() {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "shutting down"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+	})
+}
+
+// withStaticFiles serves the files under root. Requests whose path has a
+// ".." segment are rejected with 400 rather than silently cleaned, and the
+// resolved file must stay inside root.
+func withStaticFiles(root string) http.Handler {
+	files := http.FileServer(http.Dir(root))
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		absRoot = filepath.Clean(root)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hasDotDot(r.URL.Path) {
+			http.Error(w, "invalid path", http.StatusBadRequest)
+			return
+		}
+		full := filepath.Join(absRoot, filepath.FromSlash(path.Clean("/"+r.URL.Path)))
+		if full != absRoot && !strings.HasPrefix(full, absRoot+string(filepath.Separator)) {
+			http.Error(w, "invalid path", http.StatusBadRequest)
+			return
+		}
+		files.ServeHTTP(w, r)
+	})
+}
+
+// hasDotDot reports whether p contains a ".." path element, treating
+// both / and \ as separators.
+func hasDotDot(p string) bool {
+	for _, elem := range strings.FieldsFunc(p, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if elem == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// recentLogsHandler serves the lines returned by dump, oldest first, one
+// per line as plain text. Mount it at /debug/logs.
+func recentLogsHandler(dump func() []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, line := range dump() {
+			w.Write([]byte(line + "\n"))
+		}
+	})
+}
+
+// coalescedHelloHandler is helloHandler with the greeting produced by a
+// slow compute. Concurrent requests for the same path share a single
+// compute call via singleFlight, so a burst of N requests does the work
+// once.
+func coalescedHelloHandler(compute func() string) http.Handler {
+	do := singleFlight[string]()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg, _ := do(r.URL.Path, func() (string, error) {
+			return compute(), nil
+		})
+		if wantsJSON(r) {
+			writeJSON(w, http.StatusOK, map[string]string{"message": msg})
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(msg))
+	})
+}
+
+// debugErrorsHandler serves the records from withErrorCapture as JSON.
+// Mount it at /debug/errors.
+func debugErrorsHandler(records func() []ErrorRecord) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, records())
+	})
+}
+
+// streamingHandler writes each chunk source yields and flushes it right
+// away so clients can render progressively. yield returns false once a
+// write fails or the client disconnects, and source should stop then. If
+// w cannot flush, chunks are still written but may be buffered.
+func streamingHandler(source func(yield func([]byte) bool)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, canFlush := w.(http.Flusher)
+		source(func(chunk []byte) bool {
+			if r.Context().Err() != nil {
+				return false
+			}
+			if _, err := w.Write(chunk); err != nil {
+				return false
+			}
+			if canFlush {
+				flusher.Flush()
+			}
+			return true
+		})
+	})
+}
+
+// rangeHandler serves content with support for a single byte range. A
+// satisfiable Range header gets 206 with Content-Range; a malformed or
+// out-of-bounds one gets 416. Without a Range header, with a range unit
+// other than bytes (which RFC 9110 says to ignore), or with a multi-range
+// request (which this handler does not implement), the full body is sent
+// with 200.
+func rangeHandler(content []byte, contentType string) http.Handler {
+	size := int64(len(content))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Content-Type", contentType)
+		h.Set("Accept-Ranges", "bytes")
+
+		spec := r.Header.Get("Range")
+		if !strings.HasPrefix(spec, "bytes=") || strings.Contains(spec, ",") {
+			h.Set("Content-Length", strconv.FormatInt(size, 10))
+			w.WriteHeader(http.StatusOK)
+			if r.Method != http.MethodHead {
+				w.Write(content)
+			}
+			return
+		}
+
+		start, end, ok := parseRange(spec, size)
+		if !ok {
+			h.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			http.Error(w, http.StatusText(http.StatusRequestedRangeNotSatisfiable), http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		h.Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+		w.WriteHeader(http.StatusPartialContent)
+		if r.Method != http.MethodHead {
+			w.Write(content[start : end+1])
+		}
+	})
+}
+
+// parseRange parses a single "bytes=first-last", "bytes=first-" or
+// "bytes=-suffix" range against a body of size bytes and returns the
+// inclusive byte offsets it selects.
+func parseRange(spec string, size int64) (start, end int64, ok bool) {
+	r, found := strings.CutPrefix(spec, "bytes=")
+	if !found {
+		return 0, 0, false
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(r), "-")
+	if !found {
+		return 0, 0, false
+	}
+
+	if first == "" {
+		// Suffix range: the final n bytes.
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false
+		}
+		return max(size-n, 0), size - 1, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end = size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+	return start, end, true
+}
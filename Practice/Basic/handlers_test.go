@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthHandler(t *testing.T) {
+	tests := []struct {
+		method     string
+		wantStatus int
+		wantBody   string
+	}{
+		{http.MethodGet, http.StatusOK, `{"status":"ok"}`},
+		{http.MethodPost, http.StatusMethodNotAllowed, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.Handle("/healthz", healthHandler())
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(tt.method, "/healthz", nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" {
+				if got := strings.TrimSpace(rec.Body.String()); got != tt.wantBody {
+					t.Errorf("body = %s, want %s", got, tt.wantBody)
+				}
+				if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+					t.Errorf("Content-Type = %q, want application/json", ct)
+				}
+			}
+		})
+	}
+}
+
+func TestHelloHandler(t *testing.T) {
+	tests := []struct {
+		name, accept, wantType, wantBody string
+	}{
+		{"json", "application/json", "application/json", `{"message":"Hello, World!"}`},
+		{"json among others", "text/html;q=0.9, application/json;q=0.8", "application/json", `{"message":"Hello, World!"}`},
+		{"plain", "text/plain", "text/plain; charset=utf-8", "Hello, World!"},
+		{"no accept", "", "text/plain; charset=utf-8", "Hello, World!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			helloHandler().ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != tt.wantType {
+				t.Errorf("Content-Type = %q, want %q", ct, tt.wantType)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.wantBody {
+				t.Errorf("body = %s, want %s", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestReadyHandler(t *testing.T) {
+	ready, setNotReady := readiness()
+	h := readyHandler(ready)
+
+	check := func(want int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if rec.Code != want {
+			t.Errorf("status = %d, want %d", rec.Code, want)
+		}
+	}
+	check(http.StatusOK)
+	setNotReady()
+	check(http.StatusServiceUnavailable)
+	setNotReady()
+	check(http.StatusServiceUnavailable)
+}
+
+func TestWithStaticFiles(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "hello.txt"), []byte("static hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// A file next to root that traversal would reach.
+	if err := os.WriteFile(filepath.Join(filepath.Dir(root), "secret.txt"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h := withStaticFiles(root)
+
+	tests := []struct {
+		name, target string
+		wantStatus   int
+		wantBody     string
+	}{
+		{"known file", "/hello.txt", http.StatusOK, "static hello"},
+		{"missing file", "/nope.txt", http.StatusNotFound, ""},
+		{"traversal", "/../secret.txt", http.StatusBadRequest, ""},
+		{"nested traversal", "/a/../../secret.txt", http.StatusBadRequest, ""},
+		{"backslash traversal", `/..\secret.txt`, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			// Set the path directly so httptest does not clean it.
+			req.URL.Path = tt.target
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body, tt.wantBody)
+			}
+			if strings.Contains(rec.Body.String(), "secret") && tt.wantBody == "" {
+				t.Errorf("served a file outside root: %q", rec.Body)
+			}
+		})
+	}
+}
+
+func TestRecentLogsHandler(t *testing.T) {
+	log, dump := recentLogs(2)
+	for _, l := range []string{"first", "second", "third"} {
+		log(l)
+	}
+	rec := httptest.NewRecorder()
+	recentLogsHandler(dump).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/logs", nil))
+	if got, want := rec.Body.String(), "second\nthird\n"; got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %q, want text/plain", ct)
+	}
+}
+
+func TestCoalescedHelloHandler(t *testing.T) {
+	var computes atomic.Int32
+	release := make(chan struct{})
+	h := coalescedHelloHandler(func() string {
+		computes.Add(1)
+		<-release
+		return "Hello, World!"
+	})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	const n = 20
+	bodies := make(chan string, n)
+	var started sync.WaitGroup
+	started.Add(n)
+	for range n {
+		go func() {
+			started.Done()
+			resp, err := srv.Client().Get(srv.URL + "/hello")
+			if err != nil {
+				t.Error(err)
+				bodies <- ""
+				return
+			}
+			b, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			bodies <- string(b)
+		}()
+	}
+	started.Wait()
+	// Give every request time to join the in-flight compute.
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+
+	for range n {
+		if got := <-bodies; got != "Hello, World!" {
+			t.Errorf("body = %q, want %q", got, "Hello, World!")
+		}
+	}
+	if got := computes.Load(); got != 1 {
+		t.Errorf("compute ran %d times for %d concurrent requests, want 1", got, n)
+	}
+}
+
+// flushRecorder records what had been written each time Flush was called.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushes []string
+}
+
+func (f *flushRecorder) Flush() {
+	f.flushes = append(f.flushes, f.Body.String())
+}
+
+// plainWriter hides any Flusher the wrapped ResponseWriter implements.
+type plainWriter struct {
+	http.ResponseWriter
+}
+
+func TestStreamingHandler(t *testing.T) {
+	chunks := []string{"one ", "two ", "three"}
+	source := func(yield func([]byte) bool) {
+		for _, c := range chunks {
+			if !yield([]byte(c)) {
+				return
+			}
+		}
+	}
+
+	t.Run("flushes each chunk", func(t *testing.T) {
+		rec := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+		streamingHandler(source).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		want := []string{"one ", "one two ", "one two three"}
+		if !slices.Equal(rec.flushes, want) {
+			t.Errorf("body at each flush = %q, want %q", rec.flushes, want)
+		}
+	})
+
+	t.Run("without Flusher", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		streamingHandler(source).ServeHTTP(plainWriter{rec}, httptest.NewRequest(http.MethodGet, "/", nil))
+		if got := rec.Body.String(); got != "one two three" {
+			t.Errorf("body = %q, want %q", got, "one two three")
+		}
+		if rec.Flushed {
+			t.Error("recorder was flushed through a writer without Flusher")
+		}
+	})
+
+	t.Run("stops when the client goes away", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		var yields []bool
+		h := streamingHandler(func(yield func([]byte) bool) {
+			yields = append(yields, yield([]byte("a")))
+			cancel()
+			yields = append(yields, yield([]byte("b")))
+		})
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+		if !slices.Equal(yields, []bool{true, false}) || rec.Body.String() != "a" {
+			t.Errorf("yields = %v, body = %q; want [true false], \"a\"", yields, rec.Body)
+		}
+	})
+}
+
+func TestRangeHandler(t *testing.T) {
+	content := []byte("0123456789")
+	tests := []struct {
+		name             string
+		method           string
+		rangeHeader      string
+		wantStatus       int
+		wantBody         string
+		wantContentRange string
+	}{
+		{"full request", http.MethodGet, "", http.StatusOK, "0123456789", ""},
+		{"valid range", http.MethodGet, "bytes=2-5", http.StatusPartialContent, "2345", "bytes 2-5/10"},
+		{"open-ended range", http.MethodGet, "bytes=7-", http.StatusPartialContent, "789", "bytes 7-9/10"},
+		{"suffix range", http.MethodGet, "bytes=-3", http.StatusPartialContent, "789", "bytes 7-9/10"},
+		{"end clamped to size", http.MethodGet, "bytes=8-100", http.StatusPartialContent, "89", "bytes 8-9/10"},
+		{"HEAD range has no body", http.MethodHead, "bytes=0-1", http.StatusPartialContent, "", "bytes 0-1/10"},
+		{"start out of bounds", http.MethodGet, "bytes=10-12", http.StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+		{"end before start", http.MethodGet, "bytes=5-2", http.StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+		{"unknown unit is ignored", http.MethodGet, "items=0-5", http.StatusOK, "0123456789", ""},
+		{"bytes without a range", http.MethodGet, "bytes=", http.StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+		{"multiple ranges fall back to full", http.MethodGet, "bytes=0-1,4-5", http.StatusOK, "0123456789", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/file", nil)
+			if tt.rangeHeader != "" {
+				req.Header.Set("Range", tt.rangeHeader)
+			}
+			rec := httptest.NewRecorder()
+			rangeHandler(content, "text/plain").ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Content-Range"); got != tt.wantContentRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.wantContentRange)
+			}
+			if rec.Code == http.StatusRequestedRangeNotSatisfiable {
+				return
+			}
+			if rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body, tt.wantBody)
+			}
+			if got, want := rec.Header().Get("Accept-Ranges"), "bytes"; got != want {
+				t.Errorf("Accept-Ranges = %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// NewTestServer wraps h in mws (outermost first, as with Chain) and
+// starts an httptest.Server serving the result. Callers must Close it.
+func NewTestServer(mws []func(http.Handler) http.Handler, h http.Handler) *httptest.Server {
+	return httptest.NewServer(Chain(mws...)(h))
+}
+
+func TestNewTestServerEndToEnd(t *testing.T) {
+	logs := &logRecorder{}
+	srv := NewTestServer([]func(http.Handler) http.Handler{
+		withLogging(logs),
+		withRecovery(logs),
+		withAuth(logs, func(token string) bool { return token == "demo-token" }),
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/panic" {
+			panic("handler exploded")
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name       string
+		path       string
+		token      string
+		wantStatus int
+		wantBody   string
+		wantLogs   []string
+	}{
+		{"authorized", "/hello", "demo-token", http.StatusOK, "hello", []string{
+			"Authenticated method=GET path=/hello",
+			"Request method=GET path=/hello status=200",
+		}},
+		{"missing token", "/hello", "", http.StatusUnauthorized, "Unauthorized\n", []string{
+			"Unauthorized method=GET path=/hello",
+			"Request method=GET path=/hello status=401",
+		}},
+		{"wrong token", "/hello", "guess", http.StatusUnauthorized, "Unauthorized\n", []string{
+			"Unauthorized method=GET path=/hello",
+		}},
+		{"panic is recovered and logged", "/panic", "demo-token", http.StatusInternalServerError, "Internal Server Error\n", []string{
+			"Authenticated method=GET path=/panic",
+			"Panic method=GET path=/panic error=handler exploded",
+			"Request method=GET path=/panic status=500",
+		}},
+		{"auth runs before the panicking handler", "/panic", "", http.StatusUnauthorized, "Unauthorized\n", []string{
+			"Unauthorized method=GET path=/panic",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs.mu.Lock()
+			logs.lines = nil
+			logs.mu.Unlock()
+
+			req, _ := http.NewRequest(http.MethodGet, srv.URL+tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			resp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus || string(body) != tt.wantBody {
+				t.Errorf("response = %d %q, want %d %q", resp.StatusCode, body, tt.wantStatus, tt.wantBody)
+			}
+			got := logs.String()
+			for _, want := range tt.wantLogs {
+				if !strings.Contains(got, want) {
+					t.Errorf("logs missing %q:\n%s", want, got)
+				}
+			}
+			if tt.wantStatus == http.StatusUnauthorized && strings.Contains(got, "Panic") {
+				t.Errorf("handler ran for an unauthorized request:\n%s", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ShutdownHooks collects cleanup closures (closing databases, flushing
+// logs, ...) to run when the server stops. It is safe for concurrent use.
+type ShutdownHooks struct {
+	mu    sync.Mutex
+	hooks []func(context.Context) error
+}
+
+// Register adds fn to the hooks run by Run.
+func (h *ShutdownHooks) Register(fn func(context.Context) error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hooks = append(h.hooks, fn)
+}
+
+// Run calls every registered hook with ctx, most recently registered
+// first, so resources are released in the reverse order they were set
+// up. All hooks run even if some fail; their errors are joined.
+func (h *ShutdownHooks) Run(ctx context.Context) error {
+	h.mu.Lock()
+	hooks := append([]func(context.Context) error(nil), h.hooks...)
+	h.mu.Unlock()
+
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if err := hooks[i](ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// serverHooks are run by runServer and runServerTLS once in-flight
+// requests have drained, with the remaining shutdown deadline.
+var serverHooks ShutdownHooks
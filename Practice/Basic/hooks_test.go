@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestShutdownHooksOrder(t *testing.T) {
+	var h ShutdownHooks
+	var ran []string
+	for _, name := range []string{"db", "cache", "logs"} {
+		h.Register(func(context.Context) error {
+			ran = append(ran, name)
+			return nil
+		})
+	}
+	if err := h.Run(context.Background()); err != nil {
+		t.Fatalf("Run() = %v, want nil", err)
+	}
+	if want := []string{"logs", "cache", "db"}; !slices.Equal(ran, want) {
+		t.Errorf("ran %v, want %v (LIFO)", ran, want)
+	}
+}
+
+func TestShutdownHooksErrors(t *testing.T) {
+	errDB, errLogs := errors.New("close db"), errors.New("flush logs")
+	tests := []struct {
+		name     string
+		hookErrs []error
+		want     []error
+	}{
+		{"no hooks", nil, nil},
+		{"all succeed", []error{nil, nil}, nil},
+		{"one fails", []error{errDB, nil}, []error{errDB}},
+		{"several fail", []error{errDB, nil, errLogs}, []error{errDB, errLogs}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var h ShutdownHooks
+			runs := 0
+			for _, e := range tt.hookErrs {
+				h.Register(func(context.Context) error {
+					runs++
+					return e
+				})
+			}
+			err := h.Run(context.Background())
+			if runs != len(tt.hookErrs) {
+				t.Errorf("%d hooks ran, want all %d", runs, len(tt.hookErrs))
+			}
+			if (err != nil) != (len(tt.want) > 0) {
+				t.Fatalf("Run() = %v, want errors %v", err, tt.want)
+			}
+			for _, w := range tt.want {
+				if !errors.Is(err, w) {
+					t.Errorf("Run() = %v, missing %v", err, w)
+				}
+			}
+		})
+	}
+}
+
+func TestShutdownHooksContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	want, _ := ctx.Deadline()
+
+	var h ShutdownHooks
+	h.Register(func(ctx context.Context) error {
+		if got, ok := ctx.Deadline(); !ok || !got.Equal(want) {
+			t.Errorf("hook deadline = %v, %v, want %v", got, ok, want)
+		}
+		return nil
+	})
+	h.Register(func(ctx context.Context) error {
+		// A hook that outlives the deadline reports it.
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err := h.Run(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Run() = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
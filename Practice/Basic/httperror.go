@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// HTTPError is an error that carries the HTTP status it should be
+// reported with. errHandler serialises it as the JSON response body.
+type HTTPError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// NotFound returns a 404 HTTPError.
+func NotFound(msg string) error {
+	return &HTTPError{Code: http.StatusNotFound, Message: msg}
+}
+
+// BadRequest returns a 400 HTTPError.
+func BadRequest(msg string) error {
+	return &HTTPError{Code: http.StatusBadRequest, Message: msg}
+}
+
+// Unauthorized returns a 401 HTTPError.
+func Unauthorized(msg string) error {
+	return &HTTPError{Code: http.StatusUnauthorized, Message: msg}
+}
+
+// Forbidden returns a 403 HTTPError.
+func Forbidden(msg string) error {
+	return &HTTPError{Code: http.StatusForbidden, Message: msg}
+}
+
+func (e *HTTPError) Error() string {
+	return e.Message
+}
+
+// StatusCode returns the HTTP status for e.
+func (e *HTTPError) StatusCode() int {
+	return e.Code
+}
+
+// statusCoder is implemented by errors that choose their own status.
+type statusCoder interface {
+	StatusCode() int
+}
+
+// errHandler adapts a handler that returns an error. A nil error leaves
+// the response to fn; otherwise the error is written as a JSON HTTPError.
+// An error with a StatusCode method (anywhere in its chain) is reported
+// with that status, and any other error becomes a generic 500.
+func errHandler(fn func(http.ResponseWriter, *http.Request) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := fn(w, r)
+		if err == nil {
+			return
+		}
+		var he *HTTPError
+		var sc statusCoder
+		var mbe *http.MaxBytesError
+		switch {
+		case errors.As(err, &he):
+		case errors.As(err, &sc):
+			he = &HTTPError{Code: sc.StatusCode(), Message: err.Error()}
+		case errors.As(err, &mbe):
+			he = &HTTPError{Code: http.StatusRequestEntityTooLarge, Message: http.StatusText(http.StatusRequestEntityTooLarge)}
+		default:
+			he = &HTTPError{Code: http.StatusInternalServerError, Message: http.StatusText(http.StatusInternalServerError)}
+		}
+		writeJSON(w, he.Code, he)
+	})
+}
+
+// statusClientClosedRequest is the non-standard status (popularised by
+// nginx) fromTask reports when the client went away before the task ran.
+const statusClientClosedRequest = 499
+
+// fromTask serves fn, e.g. a createButtonHandlerCtx handler wrapped with
+// errOnlyCtx, as an HTTP endpoint. fn runs with the request context; nil
+// means 200, and an error is reported like errHandler does, except that a
+// cancelled context gives 499 and an expired deadline 504.
+func fromTask(fn func(context.Context) error) http.Handler {
+	return errHandler(func(w http.ResponseWriter, r *http.Request) error {
+		err := fn(r.Context())
+		switch {
+		case err == nil:
+			w.WriteHeader(http.StatusOK)
+			return nil
+		case errors.Is(err, context.Canceled):
+			return &HTTPError{Code: statusClientClosedRequest, Message: "client closed request"}
+		case errors.Is(err, context.DeadlineExceeded):
+			return &HTTPError{Code: http.StatusGatewayTimeout, Message: http.StatusText(http.StatusGatewayTimeout)}
+		}
+		return err
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// teapotError chooses its own status without being an *HTTPError.
+type teapotError struct{}
+
+func (teapotError) Error() string   { return "short and stout" }
+func (teapotError) StatusCode() int { return http.StatusTeapot }
+
+func TestErrHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantBody   string
+	}{
+		{"nil error", nil, http.StatusOK, "ok"},
+		{"plain error", errors.New("db down"), http.StatusInternalServerError, `{"code":500,"message":"Internal Server Error"}`},
+		{"HTTPError", &HTTPError{Code: http.StatusConflict, Message: "taken"}, http.StatusConflict, `{"code":409,"message":"taken"}`},
+		{"wrapped HTTPError", fmt.Errorf("lookup: %w", NotFound("no such user")), http.StatusNotFound, `{"code":404,"message":"no such user"}`},
+		{"StatusCode method", teapotError{}, http.StatusTeapot, `{"code":418,"message":"short and stout"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := errHandler(func(w http.ResponseWriter, r *http.Request) error {
+				if tt.err == nil {
+					w.Write([]byte("ok"))
+				}
+				return tt.err
+			})
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.wantBody {
+				t.Errorf("body = %s, want %s", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestHTTPErrorConstructors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"NotFound", NotFound("no such user"), http.StatusNotFound},
+		{"BadRequest", BadRequest("no such user"), http.StatusBadRequest},
+		{"Unauthorized", Unauthorized("no such user"), http.StatusUnauthorized},
+		{"Forbidden", Forbidden("no such user"), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var he *HTTPError
+			if !errors.As(tt.err, &he) {
+				t.Fatalf("%T is not an *HTTPError", tt.err)
+			}
+			if he.StatusCode() != tt.want || tt.err.Error() != "no such user" {
+				t.Errorf("got (%d, %q), want (%d, %q)", he.StatusCode(), tt.err.Error(), tt.want, "no such user")
+			}
+
+			rec := httptest.NewRecorder()
+			errHandler(func(http.ResponseWriter, *http.Request) error { return tt.err }).
+				ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			want := fmt.Sprintf(`{"code":%d,"message":"no such user"}`, tt.want)
+			if rec.Code != tt.want || strings.TrimSpace(rec.Body.String()) != want {
+				t.Errorf("response = %d %s, want %d %s", rec.Code, rec.Body, tt.want, want)
+			}
+		})
+	}
+}
+
+func TestFromTask(t *testing.T) {
+	tests := []struct {
+		name       string
+		fn         func(context.Context) error
+		cancel     bool
+		wantStatus int
+	}{
+		{"success", func(context.Context) error { return nil }, false, http.StatusOK},
+		{"plain error", func(context.Context) error { return errors.New("disk full") }, false, http.StatusInternalServerError},
+		{"HTTPError", func(context.Context) error { return NotFound("no such button") }, false, http.StatusNotFound},
+		{"client cancelled", func(ctx context.Context) error { return ctx.Err() }, true, statusClientClosedRequest},
+		{"deadline", func(context.Context) error { return fmt.Errorf("call: %w", context.DeadlineExceeded) }, false, http.StatusGatewayTimeout},
+		{"button handler", errOnlyCtx(createButtonHandlerCtx("user123", "save")), false, http.StatusOK},
+		{"cancelled button handler", errOnlyCtx(createButtonHandlerCtx("user123", "save")), true, statusClientClosedRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/task", nil)
+			if tt.cancel {
+				ctx, cancel := context.WithCancel(req.Context())
+				cancel()
+				req = req.WithContext(ctx)
+			}
+			rec := httptest.NewRecorder()
+			fromTask(tt.fn).ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import "net/http"
+
+// Store keeps responses produced for idempotency keys.
+type Store interface {
+	Get(key string) (capturedResponse, bool)
+	Set(key string, resp capturedResponse)
+}
+
+// memoryStore is an in-process Store backed by a Registry.
+type memoryStore struct {
+	*Registry[string, capturedResponse]
+}
+
+// newMemoryStore returns an empty in-memory Store.
+func newMemoryStore() Store {
+	return memoryStore{NewRegistry[string, capturedResponse]()}
+}
+
+// idempotencyHeader names the client-chosen key for a request.
+const idempotencyHeader = "Idempotency-Key"
+
+// withIdempotency replays the stored response for a request whose
+// Idempotency-Key (scoped to method and path) has been seen before,
+// instead of running next again. Requests without the header are served
+// normally. 5xx responses are not stored so the client can retry them.
+// Two concurrent requests with a new key may both run next.
+func withIdempotency(store Store) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(idempotencyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key = r.Method + " " + r.URL.Path + " " + key
+
+			if resp, ok := store.Get(key); ok {
+				// Headers already set by outer middleware for this request
+				// (a fresh request ID, say) win over the stored ones.
+				for k, v := range resp.Header {
+					if _, ok := w.Header()[k]; !ok {
+						w.Header()[k] = v
+					}
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(resp.Status)
+				w.Write(resp.Body)
+				return
+			}
+
+			cw := newCaptureWriter(w)
+			next.ServeHTTP(cw, r)
+			if resp := cw.Captured(); resp.Status < http.StatusInternalServerError {
+				store.Set(key, resp)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestWithIdempotency(t *testing.T) {
+	runs := 0
+	h := withIdempotency(newMemoryStore())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		runs++
+		if r.URL.Path == "/fail" {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("X-Order", strconv.Itoa(runs))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("order " + strconv.Itoa(runs)))
+	}))
+	do := func(method, path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if key != "" {
+			req.Header.Set(idempotencyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name         string
+		method, path string
+		key          string
+		wantRuns     int
+		wantBody     string
+		wantReplayed bool
+	}{
+		{"first request runs handler", http.MethodPost, "/orders", "k1", 1, "order 1", false},
+		{"replay skips handler", http.MethodPost, "/orders", "k1", 1, "order 1", true},
+		{"new key runs handler", http.MethodPost, "/orders", "k2", 2, "order 2", false},
+		{"key is scoped to the path", http.MethodPost, "/refunds", "k1", 3, "order 3", false},
+		{"no key always runs", http.MethodPost, "/orders", "", 4, "order 4", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(tt.method, tt.path, tt.key)
+			if runs != tt.wantRuns {
+				t.Errorf("handler runs = %d, want %d", runs, tt.wantRuns)
+			}
+			if rec.Code != http.StatusCreated || rec.Body.String() != tt.wantBody {
+				t.Errorf("response = %d %q, want %d %q", rec.Code, rec.Body.String(), http.StatusCreated, tt.wantBody)
+			}
+			if got := rec.Header().Get("Idempotent-Replayed") == "true"; got != tt.wantReplayed {
+				t.Errorf("replayed = %v, want %v", got, tt.wantReplayed)
+			}
+			if got, want := rec.Header().Get("X-Order"), tt.wantBody[len("order "):]; got != want {
+				t.Errorf("X-Order = %q, want %q", got, want)
+			}
+		})
+	}
+
+	t.Run("5xx is not stored", func(t *testing.T) {
+		before := runs
+		do(http.MethodPost, "/fail", "k3")
+		do(http.MethodPost, "/fail", "k3")
+		if runs != before+2 {
+			t.Errorf("handler ran %d times for a retried 5xx, want 2", runs-before)
+		}
+	})
+}
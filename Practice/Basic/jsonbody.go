@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+)
+
+type jsonBodyKey[T any] struct{}
+
+// withJSONBody decodes the request body as JSON into a T and stores it in
+// the request context for BodyFromContext. Malformed JSON or unknown
+// fields are rejected with a 400 HTTPError body.
+func withJSONBody[T any]() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			dec := json.NewDecoder(r.Body)
+			dec.DisallowUnknownFields()
+			var body T
+			if err := dec.Decode(&body); err != nil {
+				var mbe *http.MaxBytesError
+				if errors.As(err, &mbe) {
+					writeJSON(w, http.StatusRequestEntityTooLarge, &HTTPError{Code: http.StatusRequestEntityTooLarge, Message: err.Error()})
+					return
+				}
+				writeJSON(w, http.StatusBadRequest, &HTTPError{Code: http.StatusBadRequest, Message: "invalid JSON body: " + err.Error()})
+				return
+			}
+			ctx := context.WithValue(r.Context(), jsonBodyKey[T]{}, body)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// BodyFromContext returns the body decoded by withJSONBody[T], if any.
+func BodyFromContext[T any](ctx context.Context) (T, bool) {
+	body, ok := ctx.Value(jsonBodyKey[T]{}).(T)
+	return body, ok
+}
+
+// withFieldValidation checks that the JSON object in the request body has
+// each field in required with the given type ("string", "number" or
+// "bool"). Malformed JSON gets 400; missing or mistyped fields get 422
+// with a list of violations. The body is restored for next.
+func withFieldValidation(required map[string]string) func(http.Handler) http.Handler {
+	fields := slices.Sorted(maps.Keys(required))
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			data, err := io.ReadAll(r.Body)
+			r.Body.Close()
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, &HTTPError{Code: http.StatusBadRequest, Message: "failed to read request body"})
+				return
+			}
+			var obj map[string]any
+			if err := json.Unmarshal(data, &obj); err != nil {
+				writeJSON(w, http.StatusBadRequest, &HTTPError{Code: http.StatusBadRequest, Message: "invalid JSON body: " + err.Error()})
+				return
+			}
+
+			var violations []string
+			for _, name := range fields {
+				want := required[name]
+				v, ok := obj[name]
+				if !ok {
+					violations = append(violations, fmt.Sprintf("%s: required", name))
+					continue
+				}
+				if got := jsonType(v); got != want {
+					violations = append(violations, fmt.Sprintf("%s: expected %s, got %s", name, want, got))
+				}
+			}
+			if len(violations) > 0 {
+				writeJSON(w, http.StatusUnprocessableEntity, map[string][]string{"violations": violations})
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(data))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// jsonType names the JSON type of a value decoded into any.
+func jsonType(v any) string {
+	switch v.(type) {
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "bool"
+	case nil:
+		return "null"
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
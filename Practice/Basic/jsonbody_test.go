@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type signup struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func TestWithJSONBody(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		want       signup
+	}{
+		{"valid", `{"name":"alice","age":30}`, http.StatusOK, signup{"alice", 30}},
+		{"malformed", `{"name":`, http.StatusBadRequest, signup{}},
+		{"wrong type", `{"name":"alice","age":"thirty"}`, http.StatusBadRequest, signup{}},
+		{"unknown field", `{"name":"alice","admin":true}`, http.StatusBadRequest, signup{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got signup
+			var ok bool
+			h := withJSONBody[signup]()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, ok = BodyFromContext[signup](r.Context())
+			}))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body)))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				var he HTTPError
+				if err := json.Unmarshal(rec.Body.Bytes(), &he); err != nil || he.Code != tt.wantStatus {
+					t.Errorf("body = %s, want an HTTPError with code %d", rec.Body, tt.wantStatus)
+				}
+				return
+			}
+			if !ok || got != tt.want {
+				t.Errorf("BodyFromContext = %+v, %v; want %+v, true", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithFieldValidation(t *testing.T) {
+	required := map[string]string{"name": "string", "age": "number", "admin": "bool"}
+	tests := []struct {
+		name           string
+		body           string
+		wantStatus     int
+		wantViolations []string
+	}{
+		{"valid", `{"name":"alice","age":30,"admin":false,"extra":null}`, http.StatusOK, nil},
+		{"missing field", `{"name":"alice","admin":true}`, http.StatusUnprocessableEntity, []string{"age: required"}},
+		{"mistyped fields", `{"name":7,"age":"30","admin":true}`, http.StatusUnprocessableEntity, []string{
+			"age: expected number, got string",
+			"name: expected string, got number",
+		}},
+		{"empty object", `{}`, http.StatusUnprocessableEntity, []string{"admin: required", "age: required", "name: required"}},
+		{"malformed", `{"name":`, http.StatusBadRequest, nil},
+		{"not an object", `[1,2]`, http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			h := withFieldValidation(required)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				seen = string(b)
+			}))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body)))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body)
+			}
+			switch tt.wantStatus {
+			case http.StatusOK:
+				if seen != tt.body {
+					t.Errorf("downstream body = %q, want %q", seen, tt.body)
+				}
+			case http.StatusUnprocessableEntity:
+				var got struct{ Violations []string }
+				if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Equal(got.Violations, tt.wantViolations) {
+					t.Errorf("violations = %q, want %q", got.Violations, tt.wantViolations)
+				}
+			}
+		})
+	}
+}
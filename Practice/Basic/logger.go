@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Logger is the structured logger the middleware writes to. kv holds
+// alternating keys and values. *slog.Logger satisfies it directly; wrap a
+// *log.Logger with stdLogger.
+type Logger interface {
+	Info(msg string, kv ...any)
+}
+
+// stdLogger adapts a *log.Logger to Logger, printing the message followed
+// by key=value pairs.
+func stdLogger(l *log.Logger) Logger {
+	return stdLoggerAdapter{l}
+}
+
+type stdLoggerAdapter struct {
+	l *log.Logger
+}
+
+func (a stdLoggerAdapter) Info(msg string, kv ...any) {
+	a.l.Print(formatKV(msg, kv))
+}
+
+// formatKV renders msg and kv as "msg k1=v1 k2=v2". A trailing key with
+// no value is printed as "k=(MISSING)".
+func formatKV(msg string, kv []any) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	for i := 0; i < len(kv); i += 2 {
+		if i+1 < len(kv) {
+			fmt.Fprintf(&b, " %v=%v", kv[i], kv[i+1])
+		} else {
+			fmt.Fprintf(&b, " %v=(MISSING)", kv[i])
+		}
+	}
+	return b.String()
+}
+
+// fieldLogger is a Logger that appends fixed fields to every entry.
+type fieldLogger struct {
+	base   Logger
+	fields []any
+}
+
+func (f fieldLogger) Info(msg string, kv ...any) {
+	all := make([]any, 0, len(f.fields)+len(kv))
+	all = append(all, f.fields...)
+	f.base.Info(msg, append(all, kv...)...)
+}
+
+// withFields returns a Logger that logs to base with kv added to every
+// entry.
+func withFields(base Logger, kv ...any) Logger {
+	return fieldLogger{base: base, fields: kv}
+}
+
+type loggerKey struct{}
+
+// withContextLogger stores a request-scoped logger in the request context
+// that carries the method, path and, when withRequestID runs first, the
+// request ID. Handlers retrieve it with LoggerFromContext.
+func withContextLogger(base Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			kv := []any{"method", r.Method, "path", r.URL.Path}
+			if id, ok := RequestIDFromContext(r.Context()); ok {
+				kv = append(kv, "req", id)
+			}
+			ctx := context.WithValue(r.Context(), loggerKey{}, withFields(base, kv...))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// LoggerFromContext returns the logger stored by withContextLogger, or a
+// logger writing to the standard logger if there is none.
+func LoggerFromContext(ctx context.Context) Logger {
+	if l, ok := ctx.Value(loggerKey{}).(Logger); ok {
+		return l
+	}
+	return stdLogger(log.Default())
+}
+
+// rateLimitedLogger returns a Logger that forwards to base but drops an
+// entry if an identical one (same message and fields) was logged within
+// the last every.
+func rateLimitedLogger(base Logger, every time.Duration) Logger {
+	return rateLimitedLoggerClock(realClock{}, base, every)
+}
+
+// rateLimitedLoggerClock is rateLimitedLogger with time taken from clock.
+func rateLimitedLoggerClock(clock Clock, base Logger, every time.Duration) Logger {
+	return &limitedLogger{base: base, every: every, clock: clock, last: make(map[string]time.Time)}
+}
+
+// maxLimitedEntries is the size at which limitedLogger sweeps expired
+// entries so the map does not grow without bound.
+const maxLimitedEntries = 1024
+
+type limitedLogger struct {
+	base  Logger
+	every time.Duration
+	clock Clock
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func (l *limitedLogger) Info(msg string, kv ...any) {
+	key := formatKV(msg, kv)
+	now := l.clock.Now()
+
+	l.mu.Lock()
+	if t, ok := l.last[key]; ok && now.Sub(t) < l.every {
+		l.mu.Unlock()
+		return
+	}
+	if len(l.last) >= maxLimitedEntries {
+		for k, t := range l.last {
+			if now.Sub(t) >= l.every {
+				delete(l.last, k)
+			}
+		}
+	}
+	l.last[key] = now
+	l.mu.Unlock()
+
+	l.base.Info(msg, kv...)
+}
+
+// warnLogger and errorLogger are optional Logger extensions for leveled
+// output; *slog.Logger implements both.
+type warnLogger interface {
+	Warn(msg string, kv ...any)
+}
+
+type errorLogger interface {
+	Error(msg string, kv ...any)
+}
+
+// logWarn logs at warn level if l supports it, else via Info with a
+// level=warn field.
+func logWarn(l Logger, msg string, kv ...any) {
+	if wl, ok := l.(warnLogger); ok {
+		wl.Warn(msg, kv...)
+		return
+	}
+	l.Info(msg, append([]any{"level", "warn"}, kv...)...)
+}
+
+// logError logs at error level if l supports it, else via Info with a
+// level=error field.
+func logError(l Logger, msg string, kv ...any) {
+	if el, ok := l.(errorLogger); ok {
+		el.Error(msg, kv...)
+		return
+	}
+	l.Info(msg, append([]any{"level", "error"}, kv...)...)
+}
+
+// withErrorLogging logs each request once the handler has finished, at a
+// level chosen by the response status: info for 1xx-3xx, warn for 4xx
+// and error for 5xx.
+func withErrorLogging(l Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := newStatusRecorder(w)
+			next.ServeHTTP(rec, r)
+			kv := []any{"method", r.Method, "path", r.URL.Path, "status", rec.status}
+			switch {
+			case rec.status >= 500:
+				logError(l, "Request failed", kv...)
+			case rec.status >= 400:
+				logWarn(l, "Request rejected", kv...)
+			default:
+				l.Info("Request", kv...)
+			}
+		})
+	}
+}
+
+// lineLogger is a Logger that renders each entry with formatKV and hands
+// the line to fn, e.g. the log closure from recentLogs.
+type lineLogger func(line string)
+
+func (fn lineLogger) Info(msg string, kv ...any) {
+	fn(formatKV(msg, kv))
+}
+
+// multiLogger sends every entry to each of loggers.
+type multiLogger []Logger
+
+func (m multiLogger) Info(msg string, kv ...any) {
+	for _, l := range m {
+		l.Info(msg, kv...)
+	}
+}
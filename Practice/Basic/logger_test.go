@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// logCall is one structured call recorded by fakeLogger.
+type logCall struct {
+	msg string
+	kv  []any
+}
+
+// fakeLogger records the structured calls made to it.
+type fakeLogger struct {
+	calls []logCall
+}
+
+func (f *fakeLogger) Info(msg string, kv ...any) {
+	f.calls = append(f.calls, logCall{msg, kv})
+}
+
+func TestStdLogger(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  string
+		kv   []any
+		want string
+	}{
+		{"no fields", "hello", nil, "hello\n"},
+		{"fields", "Request", []any{"method", "GET", "status", 200}, "Request method=GET status=200\n"},
+		{"missing value", "odd", []any{"key"}, "odd key=(MISSING)\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			stdLogger(log.New(&buf, "", 0)).Info(tt.msg, tt.kv...)
+			if got := buf.String(); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddlewareStructuredLogging(t *testing.T) {
+	logs := &fakeLogger{}
+	h := withAuth(logs, func(string) bool { return false })(http.NotFoundHandler())
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/secret", nil))
+
+	want := []logCall{{"Unauthorized", []any{"method", "GET", "path", "/secret"}}}
+	if !slices.EqualFunc(logs.calls, want, func(a, b logCall) bool {
+		return a.msg == b.msg && slices.Equal(a.kv, b.kv)
+	}) {
+		t.Errorf("calls = %v, want %v", logs.calls, want)
+	}
+}
+
+func TestWithContextLogger(t *testing.T) {
+	tests := []struct {
+		name  string
+		chain func(Logger) func(http.Handler) http.Handler
+		want  []any
+	}{
+		{"without request ID", withContextLogger, []any{"method", "GET", "path", "/foo", "user", "alice"}},
+		{"with request ID", func(l Logger) func(http.Handler) http.Handler {
+			return Chain(withRequestID(), withContextLogger(l))
+		}, []any{"method", "GET", "path", "/foo", "req", "abc123", "user", "alice"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := &fakeLogger{}
+			h := tt.chain(logs)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				LoggerFromContext(r.Context()).Info("handled", "user", "alice")
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+			req.Header.Set(requestIDHeader, "abc123")
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			if len(logs.calls) != 1 {
+				t.Fatalf("got %d calls, want 1", len(logs.calls))
+			}
+			if got := logs.calls[0]; got.msg != "handled" || !slices.Equal(got.kv, tt.want) {
+				t.Errorf("call = %v, want handled %v", got, tt.want)
+			}
+		})
+	}
+
+	if LoggerFromContext(context.Background()) == nil {
+		t.Error("LoggerFromContext(empty) = nil, want a fallback logger")
+	}
+}
+
+func TestRateLimitedLogger(t *testing.T) {
+	clock := newFakeClock()
+	base := &fakeLogger{}
+	l := rateLimitedLoggerClock(clock, base, time.Second)
+
+	steps := []struct {
+		advance time.Duration
+		msg     string
+		kv      []any
+		logged  bool
+	}{
+		{0, "disk full", nil, true},
+		{100 * time.Millisecond, "disk full", nil, false},
+		{0, "disk full", []any{"dev", "sda"}, true},
+		{0, "other", nil, true},
+		{900 * time.Millisecond, "disk full", nil, true},
+		{0, "disk full", nil, false},
+	}
+	for i, step := range steps {
+		clock.Advance(step.advance)
+		before := len(base.calls)
+		l.Info(step.msg, step.kv...)
+		if logged := len(base.calls) > before; logged != step.logged {
+			t.Errorf("step %d: %q logged = %v, want %v", i, step.msg, logged, step.logged)
+		}
+	}
+}
+
+// leveledLogger records the level of each entry; it implements warnLogger
+// and errorLogger.
+type leveledLogger struct {
+	levels []string
+}
+
+func (l *leveledLogger) Info(string, ...any)  { l.levels = append(l.levels, "info") }
+func (l *leveledLogger) Warn(string, ...any)  { l.levels = append(l.levels, "warn") }
+func (l *leveledLogger) Error(string, ...any) { l.levels = append(l.levels, "error") }
+
+func TestWithErrorLogging(t *testing.T) {
+	tests := []struct {
+		status int
+		want   string
+	}{
+		{http.StatusOK, "info"},
+		{http.StatusNoContent, "info"},
+		{http.StatusFound, "info"},
+		{http.StatusBadRequest, "warn"},
+		{http.StatusNotFound, "warn"},
+		{http.StatusInternalServerError, "error"},
+		{http.StatusServiceUnavailable, "error"},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.status), func(t *testing.T) {
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(tt.status) })
+
+			var leveled leveledLogger
+			withErrorLogging(&leveled)(h).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/x", nil))
+			if !slices.Equal(leveled.levels, []string{tt.want}) {
+				t.Errorf("levels = %v, want [%s]", leveled.levels, tt.want)
+			}
+
+			// A plain Logger gets the level as a field instead.
+			var plain fakeLogger
+			withErrorLogging(&plain)(h).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/x", nil))
+			if len(plain.calls) != 1 {
+				t.Fatalf("got %d entries, want 1", len(plain.calls))
+			}
+			got := formatKV(plain.calls[0].msg, plain.calls[0].kv)
+			if hasLevel := strings.Contains(got, "level="+tt.want); hasLevel != (tt.want != "info") {
+				t.Errorf("entry = %q, level field present = %v", got, hasLevel)
+			}
+			if !strings.Contains(got, "status="+strconv.Itoa(tt.status)) {
+				t.Errorf("entry = %q, want status=%d", got, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestLRUEvictionOrder(t *testing.T) {
+	c := NewLRU[string, int](2)
+	c.Put("a", 1)
+	c.Put("b", 2)
+	c.Get("a") // b is now least recently used
+	c.Put("c", 3)
+
+	tests := []struct {
+		key    string
+		want   int
+		wantOK bool
+	}{
+		{"a", 1, true},
+		{"b", 0, false},
+		{"c", 3, true},
+	}
+	for _, tt := range tests {
+		if got, ok := c.Get(tt.key); got != tt.want || ok != tt.wantOK {
+			t.Errorf("Get(%q) = %d, %v, want %d, %v", tt.key, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestLRUCapacity(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		puts     int
+		wantLen  int
+	}{
+		{"under capacity", 5, 3, 3},
+		{"at capacity", 5, 5, 5},
+		{"over capacity", 5, 50, 5},
+		{"capacity clamped to one", 0, 3, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewLRU[int, int](tt.capacity)
+			for i := range tt.puts {
+				c.Put(i, i)
+			}
+			if got := c.Len(); got != tt.wantLen {
+				t.Errorf("Len() = %d, want %d", got, tt.wantLen)
+			}
+			// The newest entries survive.
+			if _, ok := c.Get(tt.puts - 1); !ok {
+				t.Errorf("most recent key %d was evicted", tt.puts-1)
+			}
+		})
+	}
+}
+
+func TestLRUUpdateInPlace(t *testing.T) {
+	c := NewLRU[string, string](2)
+	c.Put("a", "old")
+	c.Put("b", "b")
+	c.Put("a", "new") // refreshes a without growing
+	if got := c.Len(); got != 2 {
+		t.Errorf("Len() = %d after update, want 2", got)
+	}
+	c.Put("c", "c") // evicts b, not a
+	if got, ok := c.Get("a"); !ok || got != "new" {
+		t.Errorf("Get(a) = %q, %v, want \"new\", true", got, ok)
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Error("b survived; the update should have made a most recent")
+	}
+}
+
+func TestLRUConcurrent(t *testing.T) {
+	c := NewLRU[string, int](10)
+	var wg sync.WaitGroup
+	for i := range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			k := strconv.Itoa(i % 20)
+			c.Put(k, i)
+			c.Get(k)
+		}()
+	}
+	wg.Wait()
+	if got := c.Len(); got != 10 {
+		t.Errorf("Len() = %d, want 10", got)
+	}
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 )
 
 func adder() func(int) int {
@@ -16,6 +19,14 @@ func adder() func(int) int {
 }
 
 func main() {
+	addrFlag := flag.String("addr", "", "listen address (overrides $ADDR, default "+defaultAddr+")")
+	flag.StringVar(&configPath, "config", "", "JSON config file, re-read on SIGHUP")
+	flag.Parse()
+
+	if err := reloadConfig(); err != nil {
+		log.Fatal(err)
+	}
+
 	// Closure example 1: Basic adder
 	pos, neg := adder(), adder()
 	for i := 0; i < 10; i++ {
@@ -34,22 +45,30 @@ func main() {
 
 	// Closure example 3: Middleware with logging
 	// Create a logger instance (this was missing!)
-	myLogger := log.New(os.Stdout, "[HTTP] ", log.LstdFlags)
+	// Keep the most recent lines in memory for /debug/logs
+	logLine, dumpLogs := recentLogs(100)
+	myLogger := configuredLogger(multiLogger{
+		stdLogger(log.New(os.Stdout, "[HTTP] ", log.LstdFlags)),
+		lineLogger(logLine),
+	})
 	
 	// Create the middleware
 	logMiddleware := withLogging(myLogger)
 
 	//Create another middleware
-	authMiddleware := withAuth(myLogger)
-	
-	// Create a sample handler
-	helloHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("Hello, World!"))
+	// Requests must send "Authorization: Bearer demo-token"
+	authMiddleware := withAuth(myLogger, func(token string) bool {
+		return token == "demo-token"
 	})
+
+	// Recover from handler panics so one bad request can't crash the server
+	recoveryMiddleware := withRecovery(myLogger)
 	
-	// Wrap the handler with logging middleware
-	wrappedHandler := logMiddleware(authMiddleware(helloHandler))
+	// Remember recent 5xx responses, including recovered panics, for /debug/errors
+	errorCapture, recentErrors := withErrorCapture(50)
+
+	// Wrap the hello handler with the middleware chain (outermost first)
+	wrappedHandler := Chain(errorCapture, recoveryMiddleware, withRequestID(), logMiddleware, withConfiguredCORS(), authMiddleware)(helloHandler())
 	
 	// Demonstrate the middleware (simulate a request)
 	fmt.Println("\n--- Demonstrating HTTP Middleware ---")
@@ -58,7 +77,15 @@ func main() {
 	
 	// Note: To actually test this, you'd need to start an HTTP server
 	http.Handle("/", wrappedHandler)
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	http.Handle("/healthz", healthHandler())
+	http.Handle("/debug/logs", recentLogsHandler(dumpLogs))
+	http.Handle("/debug/errors", debugErrorsHandler(recentErrors))
+	ready, setNotReady := readiness()
+	http.Handle("/readyz", readyHandler(ready))
+	addr := resolveAddr(*addrFlag, os.Getenv("ADDR"))
+	if err := runServer(addr, http.DefaultServeMux, setNotReady); err != nil {
+		log.Fatal(err)
+	}
 	
 	_ = wrappedHandler // Prevent unused variable warning
 }
@@ -70,19 +97,31 @@ func createButtonHandler(userID string, action string) func() {
 	}
 }
 
-func withLogging(logger *log.Logger) func(http.Handler) http.Handler {
+func withLogging(logger Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			logger.Printf("Request: %s %s", r.Method, r.URL.Path)
-			next.ServeHTTP(w, r)
+			start := time.Now()
+			rec := newStatusRecorder(w)
+			next.ServeHTTP(rec, r)
+			kv := []any{"method", r.Method, "path", r.URL.Path, "status", rec.status, "duration", time.Since(start)}
+			if id, ok := RequestIDFromContext(r.Context()); ok {
+				kv = append(kv, "req", id)
+			}
+			logger.Info("Request", kv...)
 		})
 	}
 }
 
-func withAuth(logger *log.Logger) func(http.Handler) http.Handler {
+func withAuth(logger Logger, validate func(string) bool) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			logger.Printf("Authenticated: %s %s", r.Method, r.URL.Path)
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || token == "" || !validate(token) {
+				logger.Info("Unauthorized", "method", r.Method, "path", r.URL.Path)
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			logger.Info("Authenticated", "method", r.Method, "path", r.URL.Path)
 			next.ServeHTTP(w, r)
 		})
 	}
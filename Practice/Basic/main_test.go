@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithLoggingDuration(t *testing.T) {
+	const sleep = 10 * time.Millisecond
+	logs := &logRecorder{}
+	h := withLogging(logs)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(sleep)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/foo", nil))
+
+	line := logs.String()
+	m := regexp.MustCompile(`duration=(\S+)`).FindStringSubmatch(line)
+	if m == nil {
+		t.Fatalf("log %q has no duration", line)
+	}
+	d, err := time.ParseDuration(m[1])
+	if err != nil {
+		t.Fatalf("parse duration %q: %v", m[1], err)
+	}
+	if d < sleep {
+		t.Errorf("duration = %v, want at least %v", d, sleep)
+	}
+	if want := "Request method=GET path=/foo status=202"; !strings.HasPrefix(line, want) {
+		t.Errorf("log = %q, want prefix %q", line, want)
+	}
+}
+
+func TestWithAuth(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		want     int
+		wantNext bool
+	}{
+		{"missing header", "", http.StatusUnauthorized, false},
+		{"not bearer", "Basic ZGVtbzpkZW1v", http.StatusUnauthorized, false},
+		{"empty token", "Bearer ", http.StatusUnauthorized, false},
+		{"bad token", "Bearer wrong", http.StatusUnauthorized, false},
+		{"valid token", "Bearer demo-token", http.StatusOK, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			h := withAuth(&logRecorder{}, func(token string) bool {
+				return token == "demo-token"
+			})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if called != tt.wantNext {
+				t.Errorf("next called = %v, want %v", called, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestWithLoggingRequestID(t *testing.T) {
+	tests := []struct {
+		name   string
+		chain  func(Logger) func(http.Handler) http.Handler
+		wantID bool
+	}{
+		{"with request ID", func(l Logger) func(http.Handler) http.Handler {
+			return Chain(withRequestID(), withLogging(l))
+		}, true},
+		{"without request ID", withLogging, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := &logRecorder{}
+			h := tt.chain(logs)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			req := httptest.NewRequest(http.MethodGet, "/foo", nil)
+			req.Header.Set(requestIDHeader, "abc123")
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got := strings.Contains(logs.String(), "req=abc123"); got != tt.wantID {
+				t.Errorf("log %q contains req=abc123 = %v, want %v", logs.String(), got, tt.wantID)
+			}
+		})
+	}
+}
@@ -0,0 +1,1293 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// withRecovery recovers from panics in next, logs the panic value with a
+// stack trace and responds with 500 instead of crashing the server.
+func withRecovery(logger Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				if rec := recover(); rec != nil {
+					logger.Info("Panic", "method", r.Method, "path", r.URL.Path, "error", rec, "stack", string(debug.Stack()))
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				}
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// statusRecorder wraps an http.ResponseWriter and remembers the status
+// code written. A handler that never calls WriteHeader gets 200, matching
+// net/http's own behaviour.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	bytes       int
+}
+
+func newStatusRecorder(w http.ResponseWriter) *statusRecorder {
+	return &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	if !rec.wroteHeader {
+		rec.status = code
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// Flush lets streaming handlers such as withEventStream's work behind
+// middleware that uses statusRecorder.
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		rec.wroteHeader = true
+		f.Flush()
+	}
+}
+
+// Chain composes middleware so that the first one listed is the outermost:
+// Chain(a, b)(h) is equivalent to a(b(h)).
+func Chain(mws ...func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		for i := len(mws) - 1; i >= 0; i-- {
+			h = mws[i](h)
+		}
+		return h
+	}
+}
+
+// withRateLimit allows up to rps requests per second (with a burst of
+// rps) across all requests handled by the returned middleware and rejects
+// the rest with 429.
+func withRateLimit(rps int) func(http.Handler) http.Handler {
+	limiter := rate.NewLimiter(rate.Limit(rps), rps)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !limiter.Allow() {
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// withCORS sets Access-Control-Allow-Origin for requests whose Origin is
+// in allowedOrigins ("*" allows any origin) and answers OPTIONS preflight
+// requests with 204 without calling next.
+func withCORS(allowedOrigins []string) func(http.Handler) http.Handler {
+	allowAll := false
+	allowed := make(map[string]bool, len(allowedOrigins))
+	for _, o := range allowedOrigins {
+		if o == "*" {
+			allowAll = true
+		}
+		allowed[o] = true
+	}
+	return corsMiddleware(func(origin string) bool {
+		return allowAll || allowed[origin]
+	})
+}
+
+// corsMiddleware implements withCORS with the allowlist check supplied by
+// allow, so the allowlist can change at runtime.
+func corsMiddleware(allow func(origin string) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			w.Header().Add("Vary", "Origin")
+			if origin != "" && allow(origin) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", r.Header.Get("Access-Control-Request-Method"))
+				if h := r.Header.Get("Access-Control-Request-Headers"); h != "" {
+					w.Header().Set("Access-Control-Allow-Headers", h)
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requestIDHeader is the header withRequestID reads and echoes.
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// withRequestID tags every request with an ID, reusing an incoming
+// X-Request-ID header when present and generating one otherwise. The ID
+// is stored in the request context and set on the response header.
+func withRequestID() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(requestIDHeader)
+			if id == "" {
+				id = newRequestID()
+			}
+			w.Header().Set(requestIDHeader, id)
+			ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequestIDFromContext returns the ID stored by withRequestID, if any.
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok
+}
+
+// newRequestID returns a random UUID (version 4) string.
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// gzipResponseWriter sends the response body through a gzip.Writer. The
+// status is held back until the first body byte, so responses that turn
+// out to have no body (204, 304, or nothing written) and partial 206
+// responses go out uncompressed.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	status      int
+	wroteHeader bool
+	compress    bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if code < 200 {
+		// Informational responses pass straight through.
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+// start sends the held-back status, compressing if the response carries a
+// body that gzip applies to.
+func (w *gzipResponseWriter) start(hasBody bool) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	h := w.Header()
+	w.compress = hasBody && bodyAllowed(w.status) && w.status != http.StatusPartialContent &&
+		h.Get("Content-Encoding") == ""
+	if w.compress {
+		// The compressed length differs from whatever the handler computed.
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if len(b) == 0 && !w.wroteHeader {
+		return 0, nil
+	}
+	w.start(true)
+	if !w.compress {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.gz.Write(b)
+}
+
+func (w *gzipResponseWriter) Flush() {
+	w.start(true)
+	if w.compress {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close sends the status if nothing was written and finishes the gzip
+// stream otherwise.
+func (w *gzipResponseWriter) close() error {
+	w.start(false)
+	if w.compress {
+		return w.gz.Close()
+	}
+	return nil
+}
+
+// bodyAllowed reports whether a response with the given status may carry
+// a body.
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// withGzip compresses responses for clients that send
+// "Accept-Encoding: gzip". Other clients, HEAD requests and responses
+// without a body get the response unchanged.
+func withGzip() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			if r.Method == http.MethodHead || !acceptsGzip(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			gw := &gzipResponseWriter{ResponseWriter: w}
+			defer gw.close()
+			next.ServeHTTP(gw, r)
+		})
+	}
+}
+
+// acceptsGzip reports whether the Accept-Encoding header lists gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(enc, ";")
+		if strings.TrimSpace(name) != "gzip" {
+			continue
+		}
+		if q := strings.TrimSpace(params); q == "q=0" || q == "q=0.0" {
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// withTimeout responds with 503 if next does not finish within d. The
+// request context is cancelled at the deadline so well-behaved handlers
+// can stop early.
+func withTimeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.TimeoutHandler(next, d, http.StatusText(http.StatusServiceUnavailable))
+	}
+}
+
+// maxMetricKeys caps how many distinct keys withMetrics tracks, so
+// clients requesting random paths cannot grow the map without bound.
+const maxMetricKeys = 1000
+
+// metricsOverflowKey collects the requests withMetrics sees once
+// maxMetricKeys keys exist.
+const metricsOverflowKey = "other"
+
+// withMetrics returns a middleware that counts requests by method, path
+// and status, plus a function that returns a snapshot of those counts
+// keyed like "GET /foo 200". Once maxMetricKeys keys are tracked, requests
+// for new keys are counted under metricsOverflowKey.
+func withMetrics() (func(http.Handler) http.Handler, func() map[string]int) {
+	var mu sync.Mutex
+	counts := make(map[string]int)
+
+	mw := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := newStatusRecorder(w)
+			next.ServeHTTP(rec, r)
+			key := fmt.Sprintf("%s %s %d", r.Method, r.URL.Path, rec.status)
+			mu.Lock()
+			if _, ok := counts[key]; !ok && len(counts) >= maxMetricKeys {
+				key = metricsOverflowKey
+			}
+			counts[key]++
+			mu.Unlock()
+		})
+	}
+
+	snapshot := func() map[string]int {
+		mu.Lock()
+		defer mu.Unlock()
+		out := make(map[string]int, len(counts))
+		for k, v := range counts {
+			out[k] = v
+		}
+		return out
+	}
+
+	return mw, snapshot
+}
+
+// withBasicAuth requires HTTP Basic credentials matching users
+// (username -> password). Passwords are compared in constant time.
+// Failures get 401 with a WWW-Authenticate challenge.
+func withBasicAuth(users map[string]string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, pass, ok := r.BasicAuth()
+			if ok {
+				want, known := users[user]
+				match := subtle.ConstantTimeCompare([]byte(pass), []byte(want)) == 1
+				ok = known && match
+			}
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Basic realm="restricted", charset="UTF-8"`)
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// withMaxBody limits request bodies to n bytes. Requests that declare a
+// larger Content-Length are rejected with 413 up front; otherwise reads
+// past the limit fail with *http.MaxBytesError, which errHandler turns
+// into a 413 as well.
+func withMaxBody(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// withStripPrefix removes prefix from the request path before calling
+// next and answers 404 for paths outside prefix. An empty prefix passes
+// every request through unchanged.
+func withStripPrefix(prefix string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if prefix == "" {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := strings.CutPrefix(r.URL.Path, prefix)
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			rp, _ := strings.CutPrefix(r.URL.RawPath, prefix)
+			r2 := r.Clone(r.Context())
+			r2.URL.Path = p
+			r2.URL.RawPath = rp
+			next.ServeHTTP(w, r2)
+		})
+	}
+}
+
+// withAllowedMethods answers 405 with an Allow header for requests whose
+// method is not one of methods.
+func withAllowedMethods(methods ...string) func(http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(methods))
+	for _, m := range methods {
+		allowed[m] = true
+	}
+	allow := strings.Join(methods, ", ")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !allowed[r.Method] {
+				w.Header().Set("Allow", allow)
+				http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// when applies mw only to requests for which cond returns true; other
+// requests go straight to next. For example:
+//
+//	when(func(r *http.Request) bool { return strings.HasPrefix(r.URL.Path, "/admin") }, auth)
+func when(cond func(*http.Request) bool, mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		wrapped := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if cond(r) {
+				wrapped.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// defaultCSP is the Content-Security-Policy set by withSecurityHeaders
+// when no override is given.
+const defaultCSP = "default-src 'self'"
+
+// withSecurityHeaders sets X-Content-Type-Options, X-Frame-Options and
+// Content-Security-Policy on every response. An optional csp argument
+// replaces defaultCSP.
+func withSecurityHeaders(csp ...string) func(http.Handler) http.Handler {
+	policy := defaultCSP
+	if len(csp) > 0 {
+		policy = csp[0]
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			h.Set("X-Content-Type-Options", "nosniff")
+			h.Set("X-Frame-Options", "DENY")
+			h.Set("Content-Security-Policy", policy)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// errBodyReadTimeout is returned by request bodies wrapped by
+// withReadTimeout once the read deadline has passed.
+var errBodyReadTimeout = errors.New("request body read timed out")
+
+// timeoutBody maps the connection's read deadline error to
+// errBodyReadTimeout and clears the deadline once the body is fully read.
+type timeoutBody struct {
+	io.ReadCloser
+	rc *http.ResponseController
+}
+
+func (b *timeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		err = errBodyReadTimeout
+	case err == io.EOF:
+		// Once the body is consumed net/http starts a background read on
+		// the connection; left in place, the deadline would fail that read
+		// and cancel the request context of a handler still working.
+		b.rc.SetReadDeadline(time.Time{})
+	}
+	return n, err
+}
+
+// withReadTimeout gives handlers d to read the whole request body by
+// setting a read deadline on the connection. A client that stalls while
+// sending it makes reads fail with errBodyReadTimeout, guarding against
+// slowloris-style uploads. Requests without a body, and writers that
+// cannot set a read deadline, pass through untouched.
+func withReadTimeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+			rc := http.NewResponseController(w)
+			if err := rc.SetReadDeadline(time.Now().Add(d)); err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			r.Body = &timeoutBody{ReadCloser: r.Body, rc: rc}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// deadlineHeader carries an RFC 3339 deadline set by an upstream caller.
+const deadlineHeader = "X-Request-Deadline"
+
+// withDeadline applies the deadline from an X-Request-Deadline header to
+// the request context. Requests whose deadline has already passed get 504
+// and a malformed header gets 400; requests without the header pass
+// through untouched.
+func withDeadline() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			v := r.Header.Get(deadlineHeader)
+			if v == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			deadline, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, "invalid "+deadlineHeader+" header", http.StatusBadRequest)
+				return
+			}
+			if !time.Now().Before(deadline) {
+				http.Error(w, http.StatusText(http.StatusGatewayTimeout), http.StatusGatewayTimeout)
+				return
+			}
+			ctx, cancel := context.WithDeadline(r.Context(), deadline)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// bufferedResponse holds a response in memory instead of sending it, so
+// middleware can inspect or rewrite it before it goes out. Headers are
+// written straight to the wrapped writer's header map.
+type bufferedResponse struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponse(w http.ResponseWriter) *bufferedResponse {
+	return &bufferedResponse{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (b *bufferedResponse) WriteHeader(code int) {
+	b.status = code
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+// withETag buffers successful GET responses, tags them with a strong ETag
+// derived from the body and answers 304 Not Modified when the request's
+// If-None-Match already has that tag. HEAD requests pass through: handlers
+// may skip the body for them, so a hash of it would not match the GET tag.
+func withETag() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			buf := newBufferedResponse(w)
+			next.ServeHTTP(buf, r)
+
+			if buf.status != http.StatusOK {
+				w.WriteHeader(buf.status)
+				w.Write(buf.body.Bytes())
+				return
+			}
+
+			etag := w.Header().Get("ETag")
+			if etag == "" {
+				etag = fmt.Sprintf(`"%x"`, sha1.Sum(buf.body.Bytes()))
+				w.Header().Set("ETag", etag)
+			}
+			if etagMatches(r.Header.Get("If-None-Match"), etag) {
+				w.Header().Del("Content-Length")
+				w.Header().Del("Content-Type")
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			w.Write(buf.body.Bytes())
+		})
+	}
+}
+
+// etagMatches reports whether an If-None-Match header value matches
+// etag, using the weak comparison RFC 9110 prescribes for that header.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	want := strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == want {
+			return true
+		}
+	}
+	return false
+}
+
+// clfTimeFormat is the timestamp layout of the Common Log Format.
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// withAccessLog writes one Common Log Format line per request to out:
+//
+//	host - - [time] "METHOD path proto" status bytes
+func withAccessLog(out io.Writer) func(http.Handler) http.Handler {
+	var mu sync.Mutex
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := newStatusRecorder(w)
+			next.ServeHTTP(rec, r)
+
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				host = r.RemoteAddr
+			}
+			size := "-"
+			if rec.bytes > 0 {
+				size = fmt.Sprint(rec.bytes)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			fmt.Fprintf(out, "%s - - [%s] \"%s %s %s\" %d %s\n",
+				host, start.Format(clfTimeFormat), r.Method, r.URL.RequestURI(), r.Proto, rec.status, size)
+		})
+	}
+}
+
+// withSlowRequestAlert sends the path of any request that takes longer
+// than threshold to ch. The send never blocks: if ch is full the alert is
+// dropped.
+func withSlowRequestAlert(threshold time.Duration, ch chan<- string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			next.ServeHTTP(w, r)
+			if time.Since(start) <= threshold {
+				return
+			}
+			select {
+			case ch <- r.URL.Path:
+			default:
+			}
+		})
+	}
+}
+
+// withRequiredHeaders rejects requests missing any of headers with 400
+// and a body naming the missing ones.
+func withRequiredHeaders(headers ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var missing []string
+			for _, h := range headers {
+				if r.Header.Get(h) == "" {
+					missing = append(missing, http.CanonicalHeaderKey(h))
+				}
+			}
+			if len(missing) > 0 {
+				http.Error(w, "missing required headers: "+strings.Join(missing, ", "), http.StatusBadRequest)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// slashPolicy selects the canonical form withTrailingSlashRedirect
+// redirects to.
+type slashPolicy int
+
+const (
+	// stripTrailingSlash redirects /foo/ to /foo.
+	stripTrailingSlash slashPolicy = iota
+	// addTrailingSlash redirects /foo to /foo/.
+	addTrailingSlash
+)
+
+// withTrailingSlashRedirect redirects requests whose path is not in the
+// canonical form chosen by policy (stripTrailingSlash by default). The
+// root path is never redirected, the redirect target always stays on
+// this host and the query string is preserved. GET
+// and HEAD get 301; other methods get 308 so the method and body survive.
+func withTrailingSlashRedirect(policy ...slashPolicy) func(http.Handler) http.Handler {
+	p := stripTrailingSlash
+	if len(policy) > 0 {
+		p = policy[0]
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reqPath := r.URL.Path
+			canonical := reqPath
+			if reqPath != "/" {
+				switch p {
+				case stripTrailingSlash:
+					canonical = strings.TrimRight(reqPath, "/")
+				case addTrailingSlash:
+					if !strings.HasSuffix(reqPath, "/") {
+						canonical = reqPath + "/"
+					}
+				}
+			}
+			if canonical == reqPath {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			u := *r.URL
+			// Collapse leading slashes: a Location of //evil.com would
+			// send the client to another host.
+			u.Path = "/" + strings.TrimLeft(canonical, "/")
+			u.RawPath = ""
+			code := http.StatusMovedPermanently
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				code = http.StatusPermanentRedirect
+			}
+			http.Redirect(w, r, u.RequestURI(), code)
+		})
+	}
+}
+
+// withBodyLogging logs the request and response bodies to l after passing
+// each through redact, so secrets can be masked. The request body is
+// buffered and restored so next can still read it in full. A nil redact
+// logs bodies unchanged.
+func withBodyLogging(l Logger, redact func([]byte) []byte) func(http.Handler) http.Handler {
+	if redact == nil {
+		redact = func(b []byte) []byte { return b }
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reqBody, err := io.ReadAll(r.Body)
+			r.Body.Close()
+			if err != nil {
+				http.Error(w, "failed to read request body", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(reqBody))
+			l.Info("Request body", "method", r.Method, "path", r.URL.Path, "body", string(redact(bytes.Clone(reqBody))))
+
+			cw := newCaptureWriter(w)
+			next.ServeHTTP(cw, r)
+			resp := cw.Captured()
+			l.Info("Response body", "method", r.Method, "path", r.URL.Path, "status", resp.Status, "body", string(redact(resp.Body)))
+		})
+	}
+}
+
+// shadowTimeout bounds each mirrored request sent by withShadow.
+const shadowTimeout = 5 * time.Second
+
+// withShadow serves each request normally and then, in the background,
+// replays a copy of it against shadowURL with the same path and query.
+// Shadow responses and failures are discarded and never affect the
+// client. An unparsable shadowURL disables mirroring.
+func withShadow(shadowURL string) func(http.Handler) http.Handler {
+	base, err := url.Parse(shadowURL)
+	client := &http.Client{Timeout: shadowTimeout}
+
+	return func(next http.Handler) http.Handler {
+		if err != nil || base.Host == "" {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			r.Body.Close()
+			if err != nil {
+				http.Error(w, "failed to read request body", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			// Snapshot what the mirror needs before next can modify r.
+			method := r.Method
+			header := r.Header.Clone()
+			target := *base
+			target.Path = strings.TrimSuffix(base.Path, "/") + r.URL.Path
+			target.RawQuery = r.URL.RawQuery
+
+			next.ServeHTTP(w, r)
+
+			go func() {
+				req, err := http.NewRequest(method, target.String(), bytes.NewReader(body))
+				if err != nil {
+					return
+				}
+				req.Header = header
+				resp, err := client.Do(req)
+				if err != nil {
+					return
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}()
+		})
+	}
+}
+
+// withHTTPSRedirect redirects plain-HTTP requests to the same host, path
+// and query over https. A request counts as secure if it arrived over TLS
+// or a proxy set X-Forwarded-Proto: https. As with
+// withTrailingSlashRedirect, non-GET/HEAD methods get 308 instead of 301.
+func withHTTPSRedirect() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			target := url.URL{Scheme: "https", Host: r.Host, Path: r.URL.Path, RawPath: r.URL.RawPath, RawQuery: r.URL.RawQuery}
+			code := http.StatusMovedPermanently
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				code = http.StatusPermanentRedirect
+			}
+			http.Redirect(w, r, target.String(), code)
+		})
+	}
+}
+
+// withConcurrencyLimit lets at most max requests run at once, using a
+// semaphore. Requests beyond that get 503 straight away instead of
+// queueing.
+func withConcurrencyLimit(max int) func(http.Handler) http.Handler {
+	_, release, tryAcquire := semaphore(max)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !tryAcquire() {
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			}
+			defer release()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// overflowBucket is the withLatencyHistogram key counting requests slower
+// than every bucket.
+const overflowBucket = time.Duration(math.MaxInt64)
+
+// withLatencyHistogram returns a middleware that counts each request in
+// the smallest bucket its latency fits under (latency <= bucket), plus a
+// function returning a snapshot of the counts. Requests slower than all
+// buckets are counted under overflowBucket.
+func withLatencyHistogram(buckets []time.Duration) (func(http.Handler) http.Handler, func() map[time.Duration]int) {
+	bounds := slices.Clone(buckets)
+	slices.Sort(bounds)
+
+	var mu sync.Mutex
+	counts := make(map[time.Duration]int, len(bounds)+1)
+
+	mw := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			next.ServeHTTP(w, r)
+			elapsed := time.Since(start)
+
+			bucket := overflowBucket
+			if i, _ := slices.BinarySearch(bounds, elapsed); i < len(bounds) {
+				bucket = bounds[i]
+			}
+			mu.Lock()
+			counts[bucket]++
+			mu.Unlock()
+		})
+	}
+
+	snapshot := func() map[time.Duration]int {
+		mu.Lock()
+		defer mu.Unlock()
+		return maps.Clone(counts)
+	}
+
+	return mw, snapshot
+}
+
+const (
+	// csrfHeader carries the CSRF token on requests and responses.
+	csrfHeader = "X-CSRF-Token"
+	// sessionCookie is the cookie the CSRF token is bound to.
+	sessionCookie = "session"
+)
+
+// withCSRF protects state-changing requests (POST, PUT, PATCH, DELETE)
+// by requiring an X-CSRF-Token header equal to the HMAC-SHA256 of the
+// session cookie under secret; anything else gets 403. Safe methods pass
+// through and, when a session cookie is present, receive the token for
+// that session in the X-CSRF-Token response header.
+func withCSRF(secret []byte) func(http.Handler) http.Handler {
+	tokenFor := func(session string) string {
+		return signBody([]byte(session), secret)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cookie, err := r.Cookie(sessionCookie)
+			switch r.Method {
+			case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+				if err != nil || !hmac.Equal([]byte(r.Header.Get(csrfHeader)), []byte(tokenFor(cookie.Value))) {
+					http.Error(w, "invalid CSRF token", http.StatusForbidden)
+					return
+				}
+			default:
+				if err == nil {
+					w.Header().Set(csrfHeader, tokenFor(cookie.Value))
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// teeResponseWriter copies every body byte sent to the client into tee.
+type teeResponseWriter struct {
+	http.ResponseWriter
+	tee io.Writer
+	mu  *sync.Mutex
+}
+
+func (t *teeResponseWriter) Write(b []byte) (int, error) {
+	n, err := t.ResponseWriter.Write(b)
+	if n > 0 {
+		t.mu.Lock()
+		t.tee.Write(b[:n])
+		t.mu.Unlock()
+	}
+	return n, err
+}
+
+// withResponseTee copies each response body into out as it is written to
+// the client, e.g. for archiving. Headers and status pass through
+// unchanged and errors writing to out never affect the client. Writes
+// from concurrent requests may interleave in out.
+func withResponseTee(out io.Writer) func(http.Handler) http.Handler {
+	var mu sync.Mutex
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(&teeResponseWriter{ResponseWriter: w, tee: out, mu: &mu}, r)
+		})
+	}
+}
+
+// withContentType answers 415 for requests with a body whose Content-Type
+// media type, ignoring parameters such as charset, is not one of allowed.
+// Requests without a body pass through.
+func withContentType(allowed ...string) func(http.Handler) http.Handler {
+	ok := make(map[string]bool, len(allowed))
+	for _, t := range allowed {
+		ok[strings.ToLower(t)] = true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil || !ok[mediaType] {
+				http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// gzipRequestBody closes both the gzip reader and the original body.
+type gzipRequestBody struct {
+	*gzip.Reader
+	orig io.ReadCloser
+}
+
+func (b gzipRequestBody) Close() error {
+	b.Reader.Close()
+	return b.orig.Close()
+}
+
+// withGzipRequest transparently decompresses request bodies sent with
+// Content-Encoding: gzip. A body without a valid gzip header is rejected
+// with 400; corruption further into the stream surfaces as a read error
+// in the handler.
+func withGzipRequest() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, "invalid gzip request body", http.StatusBadRequest)
+				return
+			}
+			r.Body = gzipRequestBody{Reader: zr, orig: r.Body}
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// withServerTiming reports how long next took in a Server-Timing header
+// (e.g. "app;dur=12.3", in milliseconds) that browser dev tools can show.
+// The response is buffered so the header can carry the full duration.
+func withServerTiming() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			buf := newBufferedResponse(w)
+			next.ServeHTTP(buf, r)
+			ms := float64(time.Since(start).Microseconds()) / 1000
+			w.Header().Add("Server-Timing", fmt.Sprintf("app;dur=%.1f", ms))
+			w.WriteHeader(buf.status)
+			w.Write(buf.body.Bytes())
+		})
+	}
+}
+
+// withCacheControl sets Cache-Control from the first rule whose path.Match
+// pattern matches the request path, e.g. "/static/*" -> "public,
+// max-age=3600". Since map order is random, patterns are tried longest
+// first (then alphabetically) so more specific rules take precedence.
+// Paths matching no rule get no header. Note that * does not match /.
+func withCacheControl(rules map[string]string) func(http.Handler) http.Handler {
+	patterns := slices.Collect(maps.Keys(rules))
+	slices.SortFunc(patterns, func(a, b string) int {
+		if len(a) != len(b) {
+			return len(b) - len(a)
+		}
+		return strings.Compare(a, b)
+	})
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, p := range patterns {
+				if ok, _ := path.Match(p, r.URL.Path); ok {
+					w.Header().Set("Cache-Control", rules[p])
+					break
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ipIdleTimeout is how long withPerIPRateLimit keeps the limiter of a
+// client that has stopped sending requests.
+const ipIdleTimeout = 5 * time.Minute
+
+// withPerIPRateLimit is withRateLimit with a separate token bucket per
+// client IP. Buckets idle for ipIdleTimeout are swept out as requests
+// arrive. Pass trustForwardedFor=true only behind a proxy that sets
+// X-Forwarded-For; otherwise clients could pick their own key.
+func withPerIPRateLimit(rps int, trustForwardedFor ...bool) func(http.Handler) http.Handler {
+	trust := len(trustForwardedFor) > 0 && trustForwardedFor[0]
+	refill := time.Second / time.Duration(max(rps, 1))
+
+	type client struct {
+		allow    func() bool
+		lastSeen time.Time
+	}
+	var mu sync.Mutex
+	clients := make(map[string]*client)
+	lastSweep := time.Now()
+
+	allow := func(ip string) bool {
+		mu.Lock()
+		now := time.Now()
+		if now.Sub(lastSweep) > ipIdleTimeout {
+			for k, c := range clients {
+				if now.Sub(c.lastSeen) > ipIdleTimeout {
+					delete(clients, k)
+				}
+			}
+			lastSweep = now
+		}
+		c, ok := clients[ip]
+		if !ok {
+			c = &client{allow: tokenBucket(rps, refill, realClock{})}
+			clients[ip] = c
+		}
+		c.lastSeen = now
+		mu.Unlock()
+		return c.allow()
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !allow(clientIP(r, trust)) {
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientIP returns the caller's IP: the first X-Forwarded-For entry when
+// trustForwardedFor is set and the header is present, else the host part
+// of r.RemoteAddr.
+func clientIP(r *http.Request, trustForwardedFor bool) string {
+	if trustForwardedFor {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			first, _, _ := strings.Cut(xff, ",")
+			if ip := strings.TrimSpace(first); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ErrorRecord describes a request that ended in a 5xx response.
+type ErrorRecord struct {
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	Status int       `json:"status"`
+}
+
+// withErrorCapture returns a middleware that remembers the last capacity
+// requests answered with a 5xx status, and a function returning them
+// oldest first (e.g. for /debug/errors).
+func withErrorCapture(capacity int) (func(http.Handler) http.Handler, func() []ErrorRecord) {
+	var mu sync.Mutex
+	ring := make([]ErrorRecord, 0, capacity)
+	next := 0 // index of the oldest record once ring is full
+
+	record := func(e ErrorRecord) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(ring) < capacity {
+			ring = append(ring, e)
+			return
+		}
+		if capacity > 0 {
+			ring[next] = e
+			next = (next + 1) % capacity
+		}
+	}
+
+	mw := func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := newStatusRecorder(w)
+			h.ServeHTTP(rec, r)
+			if rec.status >= http.StatusInternalServerError {
+				record(ErrorRecord{Time: time.Now(), Method: r.Method, Path: r.URL.Path, Status: rec.status})
+			}
+		})
+	}
+
+	records := func() []ErrorRecord {
+		mu.Lock()
+		defer mu.Unlock()
+		return append(slices.Clone(ring[next:]), ring[:next]...)
+	}
+
+	return mw, records
+}
+
+// withHeaderLimits answers 431 Request Header Fields Too Large when the
+// request URI is longer than maxURLLen or the header keys and values add
+// up to more than maxHeaderBytes. A limit <= 0 is not enforced.
+func withHeaderLimits(maxHeaderBytes, maxURLLen int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tooBig := maxURLLen > 0 && len(r.URL.RequestURI()) > maxURLLen
+			if !tooBig && maxHeaderBytes > 0 {
+				size := 0
+				for k, vs := range r.Header {
+					for _, v := range vs {
+						size += len(k) + len(v)
+					}
+				}
+				tooBig = size > maxHeaderBytes
+			}
+			if tooBig {
+				http.Error(w, http.StatusText(http.StatusRequestHeaderFieldsTooLarge), http.StatusRequestHeaderFieldsTooLarge)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// signatureHeader carries the hex HMAC-SHA256 of the response body.
+const signatureHeader = "X-Signature"
+
+// signBody returns the hex HMAC-SHA256 of body under secret.
+func signBody(body, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// withResponseSignature buffers each response and sends it with an
+// X-Signature header holding the HMAC-SHA256 of the body, which clients
+// can check with verifySignature.
+func withResponseSignature(secret []byte) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			buf := newBufferedResponse(w)
+			next.ServeHTTP(buf, r)
+			w.Header().Set(signatureHeader, signBody(buf.body.Bytes(), secret))
+			w.WriteHeader(buf.status)
+			w.Write(buf.body.Bytes())
+		})
+	}
+}
+
+// verifySignature reports whether sig is the signature
+// withResponseSignature would send for body.
+func verifySignature(body []byte, sig string, secret []byte) bool {
+	return hmac.Equal([]byte(sig), []byte(signBody(body, secret)))
+}
+
+// withCleanPath redirects requests whose path contains duplicate slashes
+// or . and .. elements to the path.Clean form. Like net/http's ServeMux
+// it keeps a trailing slash, leaving that choice to
+// withTrailingSlashRedirect. Clean paths, including "/", pass through.
+func withCleanPath() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reqPath := r.URL.Path
+			if reqPath == "" {
+				reqPath = "/"
+			}
+			clean := path.Clean("/" + reqPath)
+			if strings.HasSuffix(reqPath, "/") && clean != "/" {
+				clean += "/"
+			}
+			if clean == r.URL.Path {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			u := *r.URL
+			u.Path = clean
+			u.RawPath = ""
+			code := http.StatusMovedPermanently
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				code = http.StatusPermanentRedirect
+			}
+			http.Redirect(w, r, u.RequestURI(), code)
+		})
+	}
+}
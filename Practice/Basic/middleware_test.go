@@ -0,0 +1,1699 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// logRecorder is a Logger that keeps every entry, rendered with formatKV,
+// for assertions.
+type logRecorder struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *logRecorder) Info(msg string, kv ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, formatKV(msg, kv))
+}
+
+func (l *logRecorder) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Join(l.lines, "\n")
+}
+
+func TestWithRecovery(t *testing.T) {
+	logs := &logRecorder{}
+	h := withRecovery(logs)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/panic", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	got := logs.String()
+	for _, want := range []string{"Panic", "error=boom", "path=/panic", "goroutine"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("log %q does not contain %q", got, want)
+		}
+	}
+}
+
+func TestStatusRecorder(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    int
+	}{
+		{"explicit", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		}, http.StatusTeapot},
+		{"implicit write", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("hi"))
+		}, http.StatusOK},
+		{"nothing written", func(w http.ResponseWriter, r *http.Request) {}, http.StatusOK},
+		{"second WriteHeader ignored", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			w.WriteHeader(http.StatusInternalServerError)
+		}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := newStatusRecorder(httptest.NewRecorder())
+			tt.handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rec.status != tt.want {
+				t.Errorf("status = %d, want %d", rec.status, tt.want)
+			}
+		})
+	}
+}
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	record := func(name string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	h := Chain(record("a"), record("b"), record("c"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got, want := strings.Join(order, ","), "a,b,c,handler"; got != want {
+		t.Errorf("order = %s, want %s", got, want)
+	}
+}
+
+func TestWithRateLimitBurst(t *testing.T) {
+	const rps, burst = 5, 20
+	h := withRateLimit(rps)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	counts := map[int]int{}
+	for range burst {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		counts[rec.Code]++
+	}
+	if counts[http.StatusOK] < rps {
+		t.Errorf("%d requests passed, want at least %d", counts[http.StatusOK], rps)
+	}
+	if counts[http.StatusTooManyRequests] == 0 {
+		t.Errorf("no request got 429 in a burst of %d at %d rps: %v", burst, rps, counts)
+	}
+	if counts[http.StatusOK]+counts[http.StatusTooManyRequests] != burst {
+		t.Errorf("unexpected status codes: %v", counts)
+	}
+}
+
+func TestWithCORS(t *testing.T) {
+	tests := []struct {
+		name       string
+		allowed    []string
+		method     string
+		origin     string
+		preflight  bool
+		wantOrigin string
+		wantStatus int
+		wantNext   bool
+	}{
+		{"allowed origin", []string{"https://a.example"}, http.MethodGet, "https://a.example", false, "https://a.example", http.StatusOK, true},
+		{"disallowed origin", []string{"https://a.example"}, http.MethodGet, "https://b.example", false, "", http.StatusOK, true},
+		{"wildcard", []string{"*"}, http.MethodGet, "https://b.example", false, "https://b.example", http.StatusOK, true},
+		{"preflight", []string{"https://a.example"}, http.MethodOptions, "https://a.example", true, "https://a.example", http.StatusNoContent, false},
+		{"plain OPTIONS", []string{"https://a.example"}, http.MethodOptions, "https://a.example", false, "https://a.example", http.StatusOK, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			h := withCORS(tt.allowed)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			}))
+			req := httptest.NewRequest(tt.method, "/", nil)
+			req.Header.Set("Origin", tt.origin)
+			if tt.preflight {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPut)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if called != tt.wantNext {
+				t.Errorf("next called = %v, want %v", called, tt.wantNext)
+			}
+			if tt.preflight {
+				if got := rec.Header().Get("Access-Control-Allow-Methods"); got != http.MethodPut {
+					t.Errorf("Access-Control-Allow-Methods = %q, want %q", got, http.MethodPut)
+				}
+			}
+		})
+	}
+}
+
+func TestWithRequestID(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	tests := []struct {
+		name     string
+		incoming string
+	}{
+		{"generated", ""},
+		{"passthrough", "abc123"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ctxID string
+			var ok bool
+			h := withRequestID()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctxID, ok = RequestIDFromContext(r.Context())
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.incoming != "" {
+				req.Header.Set(requestIDHeader, tt.incoming)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if !ok {
+				t.Fatal("no request ID in context")
+			}
+			if got := rec.Header().Get(requestIDHeader); got != ctxID {
+				t.Errorf("response header = %q, context = %q", got, ctxID)
+			}
+			if tt.incoming != "" && ctxID != tt.incoming {
+				t.Errorf("ID = %q, want incoming %q", ctxID, tt.incoming)
+			}
+			if tt.incoming == "" && !uuid.MatchString(ctxID) {
+				t.Errorf("generated ID %q is not a v4 UUID", ctxID)
+			}
+		})
+	}
+
+	if _, ok := RequestIDFromContext(context.Background()); ok {
+		t.Error("RequestIDFromContext(empty) reported an ID")
+	}
+}
+
+func TestWithGzip(t *testing.T) {
+	const body = "hello, hello, hello, hello"
+	plain := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Write([]byte(body))
+	}
+	tests := []struct {
+		name        string
+		method      string
+		accept      string
+		handler     http.HandlerFunc
+		wantStatus  int
+		wantGzip    bool
+		wantBody    string
+		wantRawSize int // checked when >= 0
+	}{
+		{"gzip client", http.MethodGet, "gzip", plain, http.StatusOK, true, body, -1},
+		{"gzip with q", http.MethodGet, "deflate, gzip;q=0.5", plain, http.StatusOK, true, body, -1},
+		{"gzip refused", http.MethodGet, "gzip;q=0", plain, http.StatusOK, false, body, len(body)},
+		{"plain client", http.MethodGet, "", plain, http.StatusOK, false, body, len(body)},
+		{"HEAD", http.MethodHead, "gzip", plain, http.StatusOK, false, "", 0},
+		{"204", http.MethodGet, "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}, http.StatusNoContent, false, "", 0},
+		{"304", http.MethodGet, "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotModified)
+		}, http.StatusNotModified, false, "", 0},
+		{"empty 200", http.MethodGet, "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}, http.StatusOK, false, "", 0},
+		{"206", http.MethodGet, "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Range", "bytes 0-4/26")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte(body[:5]))
+		}, http.StatusPartialContent, false, body[:5], 5},
+		{"already encoded", http.MethodGet, "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "br")
+			w.Write([]byte("br-data"))
+		}, http.StatusOK, false, "br-data", 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(withGzip()(tt.handler))
+			defer srv.Close()
+
+			// The transport must not add Accept-Encoding or decompress
+			// the body itself.
+			client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+			req, _ := http.NewRequest(tt.method, srv.URL, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept-Encoding", tt.accept)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			raw, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("read body: %v", err)
+			}
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			gotGzip := resp.Header.Get("Content-Encoding") == "gzip"
+			if gotGzip != tt.wantGzip {
+				t.Fatalf("gzip encoded = %v, want %v", gotGzip, tt.wantGzip)
+			}
+			got := string(raw)
+			if gotGzip {
+				zr, err := gzip.NewReader(bytes.NewReader(raw))
+				if err != nil {
+					t.Fatalf("gzip.NewReader: %v", err)
+				}
+				dec, err := io.ReadAll(zr)
+				if err != nil {
+					t.Fatalf("decode gzip body: %v", err)
+				}
+				got = string(dec)
+			}
+			if got != tt.wantBody {
+				t.Errorf("body = %q, want %q", got, tt.wantBody)
+			}
+			if tt.wantRawSize >= 0 && len(raw) != tt.wantRawSize {
+				t.Errorf("raw body is %d bytes, want %d", len(raw), tt.wantRawSize)
+			}
+			if vary := resp.Header.Values("Vary"); !slices.Contains(vary, "Accept-Encoding") {
+				t.Errorf("Vary = %v, want Accept-Encoding", vary)
+			}
+		})
+	}
+}
+
+func TestWithGzipStreamingFlush(t *testing.T) {
+	h := withGzip()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("part1 "))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("part2"))
+	}))
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	h.ServeHTTP(rec, req)
+
+	if !rec.Flushed {
+		t.Error("Flush did not reach the underlying writer")
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "part1 part2" {
+		t.Errorf("body = %q, want %q", got, "part1 part2")
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	tests := []struct {
+		name       string
+		delay      time.Duration
+		wantStatus int
+		wantBody   string
+	}{
+		{"fast", 0, http.StatusOK, "done"},
+		{"slow", time.Second, http.StatusServiceUnavailable, http.StatusText(http.StatusServiceUnavailable)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cancelled := make(chan struct{})
+			h := withTimeout(20 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-time.After(tt.delay):
+					w.Write([]byte("done"))
+				case <-r.Context().Done():
+					close(cancelled)
+				}
+			}))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Body.String(); got != tt.wantBody {
+				t.Errorf("body = %q, want %q", got, tt.wantBody)
+			}
+			if tt.delay > 0 {
+				select {
+				case <-cancelled:
+				case <-time.After(time.Second):
+					t.Error("request context was not cancelled")
+				}
+			}
+		})
+	}
+}
+
+func TestWithMetrics(t *testing.T) {
+	mw, snapshot := withMetrics()
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+		}
+	}))
+
+	requests := []struct {
+		method, path string
+		n            int
+	}{
+		{http.MethodGet, "/foo", 3},
+		{http.MethodPost, "/foo", 1},
+		{http.MethodGet, "/missing", 2},
+	}
+	var wg sync.WaitGroup
+	for _, req := range requests {
+		for range req.n {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(req.method, req.path, nil))
+			}()
+		}
+	}
+	wg.Wait()
+
+	want := map[string]int{"GET /foo 200": 3, "POST /foo 200": 1, "GET /missing 404": 2}
+	if got := snapshot(); !maps.Equal(got, want) {
+		t.Errorf("snapshot() = %v, want %v", got, want)
+	}
+}
+
+func TestWithMetricsBoundedKeys(t *testing.T) {
+	mw, snapshot := withMetrics()
+	h := mw(http.NotFoundHandler())
+	const extra = 5
+	for i := range maxMetricKeys + extra {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/random/"+strconv.Itoa(i), nil))
+	}
+
+	got := snapshot()
+	if len(got) != maxMetricKeys+1 {
+		t.Errorf("snapshot has %d keys, want %d", len(got), maxMetricKeys+1)
+	}
+	if got[metricsOverflowKey] != extra {
+		t.Errorf("overflow count = %d, want %d", got[metricsOverflowKey], extra)
+	}
+}
+
+func TestWithBasicAuth(t *testing.T) {
+	users := map[string]string{"alice": "s3cret"}
+	tests := []struct {
+		name       string
+		user, pass string
+		setAuth    bool
+		want       int
+	}{
+		{"valid", "alice", "s3cret", true, http.StatusOK},
+		{"wrong password", "alice", "nope", true, http.StatusUnauthorized},
+		{"unknown user", "bob", "s3cret", true, http.StatusUnauthorized},
+		{"unknown user empty password", "bob", "", true, http.StatusUnauthorized},
+		{"missing", "", "", false, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := withBasicAuth(users)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.setAuth {
+				req.SetBasicAuth(tt.user, tt.pass)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			challenge := rec.Header().Get("WWW-Authenticate")
+			if tt.want == http.StatusUnauthorized && !strings.HasPrefix(challenge, "Basic ") {
+				t.Errorf("WWW-Authenticate = %q, want a Basic challenge", challenge)
+			}
+		})
+	}
+}
+
+func TestWithMaxBody(t *testing.T) {
+	const limit = 10
+	tests := []struct {
+		name          string
+		body          string
+		unknownLength bool
+		want          int
+	}{
+		{"under limit", "small", false, http.StatusOK},
+		{"at limit", strings.Repeat("x", limit), false, http.StatusOK},
+		{"over limit", strings.Repeat("x", limit+1), false, http.StatusRequestEntityTooLarge},
+		{"over limit, chunked", strings.Repeat("x", 100), true, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := withMaxBody(limit)(errHandler(func(w http.ResponseWriter, r *http.Request) error {
+				if _, err := io.ReadAll(r.Body); err != nil {
+					return err
+				}
+				w.WriteHeader(http.StatusOK)
+				return nil
+			}))
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			if tt.unknownLength {
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithStripPrefix(t *testing.T) {
+	tests := []struct {
+		name, prefix, path string
+		wantStatus         int
+		wantPath           string
+	}{
+		{"matching", "/api", "/api/users", http.StatusOK, "/users"},
+		{"not matching", "/api", "/other/users", http.StatusNotFound, ""},
+		{"empty prefix", "", "/api/users", http.StatusOK, "/api/users"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotPath string
+			h := withStripPrefix(tt.prefix)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotPath = r.URL.Path
+			}))
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if gotPath != tt.wantPath {
+				t.Errorf("next saw path %q, want %q", gotPath, tt.wantPath)
+			}
+			if req.URL.Path != tt.path {
+				t.Errorf("original request path changed to %q", req.URL.Path)
+			}
+		})
+	}
+}
+
+func TestWithAllowedMethods(t *testing.T) {
+	tests := []struct {
+		method     string
+		wantStatus int
+		wantAllow  string
+	}{
+		{http.MethodGet, http.StatusOK, ""},
+		{http.MethodPost, http.StatusOK, ""},
+		{http.MethodDelete, http.StatusMethodNotAllowed, "GET, POST"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			h := withAllowedMethods(http.MethodGet, http.MethodPost)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(tt.method, "/", nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Allow"); got != tt.wantAllow {
+				t.Errorf("Allow = %q, want %q", got, tt.wantAllow)
+			}
+		})
+	}
+}
+
+func TestWhen(t *testing.T) {
+	isAdmin := func(r *http.Request) bool { return strings.HasPrefix(r.URL.Path, "/admin") }
+	tests := []struct {
+		path    string
+		wantMW  bool
+		wantHit bool
+	}{
+		{"/admin/users", true, true},
+		{"/public", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			mwRan, hit := false, false
+			mw := func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					mwRan = true
+					next.ServeHTTP(w, r)
+				})
+			}
+			h := when(isAdmin, mw)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				hit = true
+			}))
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if mwRan != tt.wantMW || hit != tt.wantHit {
+				t.Errorf("middleware ran = %v, handler ran = %v; want %v, %v", mwRan, hit, tt.wantMW, tt.wantHit)
+			}
+		})
+	}
+}
+
+func TestWithSecurityHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		csp     []string
+		wantCSP string
+	}{
+		{"default CSP", nil, defaultCSP},
+		{"custom CSP", []string{"default-src 'none'"}, "default-src 'none'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := withSecurityHeaders(tt.csp...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			want := map[string]string{
+				"X-Content-Type-Options":  "nosniff",
+				"X-Frame-Options":         "DENY",
+				"Content-Security-Policy": tt.wantCSP,
+			}
+			for k, v := range want {
+				if got := rec.Header().Get(k); got != v {
+					t.Errorf("%s = %q, want %q", k, got, v)
+				}
+			}
+		})
+	}
+}
+
+func TestWithReadTimeout(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+	type outcome struct {
+		readErr, nextErr, ctxErr error
+		elapsed                  time.Duration
+	}
+	tests := []struct {
+		name    string
+		request string
+		wantErr error
+		// wantNext is what a Read after the body is drained returns.
+		wantNext error
+	}{
+		{"full body", "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 3\r\n\r\nabc", nil, io.EOF},
+		{"stalled body", "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 100\r\n\r\nabc", errBodyReadTimeout, errBodyReadTimeout},
+		{"no body", "GET / HTTP/1.1\r\nHost: x\r\n\r\n", nil, io.EOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(chan outcome, 1)
+			srv := httptest.NewServer(withReadTimeout(timeout)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				start := time.Now()
+				_, readErr := io.ReadAll(r.Body)
+				elapsed := time.Since(start)
+				_, nextErr := r.Body.Read(make([]byte, 1))
+				// A handler that outlives the deadline after reading the
+				// body must keep its context.
+				var ctxErr error
+				if readErr == nil {
+					time.Sleep(2 * timeout)
+					ctxErr = r.Context().Err()
+				}
+				got <- outcome{readErr, nextErr, ctxErr, elapsed}
+			})))
+			defer srv.Close()
+
+			conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if _, err := io.WriteString(conn, tt.request); err != nil {
+				t.Fatal(err)
+			}
+
+			var o outcome
+			select {
+			case o = <-got:
+			case <-time.After(2 * time.Second):
+				t.Fatal("handler still blocked reading the body after 2s")
+			}
+			if !errors.Is(o.readErr, tt.wantErr) {
+				t.Errorf("read error = %v, want %v", o.readErr, tt.wantErr)
+			}
+			if !errors.Is(o.nextErr, tt.wantNext) {
+				t.Errorf("next read error = %v, want %v", o.nextErr, tt.wantNext)
+			}
+			if tt.wantErr != nil && o.elapsed < timeout {
+				t.Errorf("read failed after %v, before the %v timeout", o.elapsed, timeout)
+			}
+			if o.ctxErr != nil {
+				t.Errorf("request context = %v after the body was read, want nil", o.ctxErr)
+			}
+		})
+	}
+}
+
+func TestWithDeadline(t *testing.T) {
+	tests := []struct {
+		name         string
+		header       string
+		wantStatus   int
+		wantDeadline bool
+	}{
+		{"future", time.Now().Add(time.Hour).Format(time.RFC3339), http.StatusOK, true},
+		{"past", time.Now().Add(-time.Hour).Format(time.RFC3339), http.StatusGatewayTimeout, false},
+		{"no header", "", http.StatusOK, false},
+		{"malformed", "tomorrow", http.StatusBadRequest, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called, hasDeadline := false, false
+			h := withDeadline()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				_, hasDeadline = r.Context().Deadline()
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(deadlineHeader, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if called != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("next called = %v", called)
+			}
+			if hasDeadline != tt.wantDeadline {
+				t.Errorf("context has deadline = %v, want %v", hasDeadline, tt.wantDeadline)
+			}
+		})
+	}
+}
+
+func TestWithETag(t *testing.T) {
+	const body = "cacheable"
+	h := withETag()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(body))
+	}))
+
+	first := httptest.NewRecorder()
+	h.ServeHTTP(first, httptest.NewRequest(http.MethodGet, "/", nil))
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || first.Body.String() != body || etag == "" {
+		t.Fatalf("first response = %d %q ETag %q, want 200 %q with an ETag", first.Code, first.Body, etag, body)
+	}
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		wantStatus  int
+		wantBody    string
+	}{
+		{"matching", etag, http.StatusNotModified, ""},
+		{"weak match", "W/" + etag, http.StatusNotModified, ""},
+		{"in list", `"other", ` + etag, http.StatusNotModified, ""},
+		{"wildcard", "*", http.StatusNotModified, ""},
+		{"stale", `"other"`, http.StatusOK, body},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus || rec.Body.String() != tt.wantBody {
+				t.Errorf("response = %d %q, want %d %q", rec.Code, rec.Body, tt.wantStatus, tt.wantBody)
+			}
+			if got := rec.Header().Get("ETag"); got != etag {
+				t.Errorf("ETag = %q, want %q", got, etag)
+			}
+		})
+	}
+}
+
+func TestWithETagHead(t *testing.T) {
+	// rangeHandler skips the body on HEAD, so HEAD must not be tagged
+	// with the hash of an empty body.
+	h := withETag()(rangeHandler([]byte("0123456789"), "text/plain"))
+	etags := make(map[string]string)
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, "/file", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s status = %d, want 200", method, rec.Code)
+		}
+		etags[method] = rec.Header().Get("ETag")
+	}
+	if etags[http.MethodGet] == "" {
+		t.Fatal("GET response has no ETag")
+	}
+	if head := etags[http.MethodHead]; head != "" && head != etags[http.MethodGet] {
+		t.Errorf("HEAD ETag = %q, GET ETag = %q, want HEAD to match GET or be absent", head, etags[http.MethodGet])
+	}
+}
+
+func TestWithAccessLog(t *testing.T) {
+	clf := regexp.MustCompile(`^(\S+) - - \[(\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4})\] "(\S+) (\S+) (\S+)" (\d{3}) (\d+|-)\n$`)
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    []string // host, method, URI, proto, status, bytes
+	}{
+		{"body", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("hello"))
+		}, []string{"192.0.2.1", "GET", "/foo?x=1", "HTTP/1.1", "200", "5"}},
+		{"no body", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}, []string{"192.0.2.1", "GET", "/foo?x=1", "HTTP/1.1", "204", "-"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			withAccessLog(&out)(tt.handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/foo?x=1", nil))
+
+			m := clf.FindStringSubmatch(out.String())
+			if m == nil {
+				t.Fatalf("line %q is not in Common Log Format", out.String())
+			}
+			if _, err := time.Parse(clfTimeFormat, m[2]); err != nil {
+				t.Errorf("timestamp %q: %v", m[2], err)
+			}
+			got := append([]string{m[1]}, m[3:]...)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("fields = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithSlowRequestAlert(t *testing.T) {
+	const threshold = 20 * time.Millisecond
+	tests := []struct {
+		name    string
+		delay   time.Duration
+		wantHit bool
+	}{
+		{"slow", 2 * threshold, true},
+		{"fast", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch := make(chan string, 1)
+			h := withSlowRequestAlert(threshold, ch)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(tt.delay)
+			}))
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/report", nil))
+
+			select {
+			case path := <-ch:
+				if !tt.wantHit || path != "/report" {
+					t.Errorf("alert %q, want hit %v for /report", path, tt.wantHit)
+				}
+			default:
+				if tt.wantHit {
+					t.Error("no alert for a slow request")
+				}
+			}
+		})
+	}
+}
+
+func TestWithSlowRequestAlertFullChannel(t *testing.T) {
+	ch := make(chan string) // unbuffered and never read
+	h := withSlowRequestAlert(0, ch)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond)
+	}))
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("middleware blocked on a full channel")
+	}
+}
+
+func TestWithRequiredHeaders(t *testing.T) {
+	tests := []struct {
+		name       string
+		required   []string
+		sent       map[string]string
+		wantStatus int
+		wantBody   string
+	}{
+		{"all present", []string{"X-API-Version", "x-tenant"}, map[string]string{"X-Api-Version": "2", "X-Tenant": "acme"}, http.StatusOK, ""},
+		{"some missing", []string{"X-API-Version", "x-tenant", "X-Trace"}, map[string]string{"X-Tenant": "acme"}, http.StatusBadRequest, "missing required headers: X-Api-Version, X-Trace\n"},
+		{"none required", nil, nil, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := withRequiredHeaders(tt.required...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for k, v := range tt.sent {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus || rec.Body.String() != tt.wantBody {
+				t.Errorf("response = %d %q, want %d %q", rec.Code, rec.Body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestWithTrailingSlashRedirect(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       []slashPolicy
+		method       string
+		target       string
+		wantStatus   int
+		wantLocation string
+	}{
+		{"trailing slash redirects", nil, http.MethodGet, "/foo/", http.StatusMovedPermanently, "/foo"},
+		{"query preserved", nil, http.MethodGet, "/foo/?q=1", http.StatusMovedPermanently, "/foo?q=1"},
+		{"POST keeps method", nil, http.MethodPost, "/foo/", http.StatusPermanentRedirect, "/foo"},
+		{"root untouched", nil, http.MethodGet, "/", http.StatusOK, ""},
+		{"canonical passes", nil, http.MethodGet, "/foo", http.StatusOK, ""},
+		{"add policy redirects", []slashPolicy{addTrailingSlash}, http.MethodGet, "/foo", http.StatusMovedPermanently, "/foo/"},
+		{"add policy canonical", []slashPolicy{addTrailingSlash}, http.MethodGet, "/foo/", http.StatusOK, ""},
+		{"protocol-relative host", nil, http.MethodGet, "//evil.com/", http.StatusMovedPermanently, "/evil.com"},
+		{"many leading slashes", nil, http.MethodGet, "///evil.com//", http.StatusMovedPermanently, "/evil.com"},
+		{"protocol-relative host, add policy", []slashPolicy{addTrailingSlash}, http.MethodGet, "//evil.com", http.StatusMovedPermanently, "/evil.com/"},
+		{"backslash host", nil, http.MethodGet, `/\evil.com/`, http.StatusMovedPermanently, "/%5Cevil.com"},
+		{"only slashes", nil, http.MethodGet, "//", http.StatusMovedPermanently, "/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := withTrailingSlashRedirect(tt.policy...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestWithTrailingSlashRedirectRawRequest(t *testing.T) {
+	srv := httptest.NewServer(withTrailingSlashRedirect()(http.NotFoundHandler()))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET //evil.com/ HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc.Host != "" || loc.Path != "/evil.com" {
+		t.Errorf("Location = %q, want the same-host path /evil.com", resp.Header.Get("Location"))
+	}
+}
+
+func TestWithBodyLogging(t *testing.T) {
+	password := regexp.MustCompile(`"password":"[^"]*"`)
+	redact := func(b []byte) []byte {
+		return password.ReplaceAll(b, []byte(`"password":"***"`))
+	}
+	const reqBody = `{"user":"alice","password":"hunter2"}`
+
+	logs := &logRecorder{}
+	var handlerSaw string
+	h := withBodyLogging(logs, redact)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		handlerSaw = string(b)
+		w.Write([]byte(`{"token":"t","password":"echoed"}`))
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(reqBody)))
+
+	if handlerSaw != reqBody {
+		t.Errorf("handler read %q, want the original %q", handlerSaw, reqBody)
+	}
+	if got := rec.Body.String(); got != `{"token":"t","password":"echoed"}` {
+		t.Errorf("client got %q, want the unredacted response", got)
+	}
+	out := logs.String()
+	for _, secret := range []string{"hunter2", "echoed"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("log %q leaks %q", out, secret)
+		}
+	}
+	for _, want := range []string{`Request body method=POST path=/login body={"user":"alice","password":"***"}`, "Response body", "status=200"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log %q does not contain %q", out, want)
+		}
+	}
+}
+
+func TestWithShadow(t *testing.T) {
+	type mirrored struct {
+		method, uri, body, header string
+	}
+	got := make(chan mirrored, 1)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got <- mirrored{r.Method, r.URL.RequestURI(), string(b), r.Header.Get("X-Test")}
+		// Shadow failures must not reach the client.
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer shadow.Close()
+
+	h := withShadow(shadow.URL + "/mirror")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		w.Write([]byte("primary saw " + string(b)))
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/orders?id=7", strings.NewReader("payload"))
+	req.Header.Set("X-Test", "yes")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "primary saw payload" {
+		t.Errorf("client got %d %q, want 200 %q", rec.Code, rec.Body, "primary saw payload")
+	}
+	select {
+	case m := <-got:
+		want := mirrored{http.MethodPost, "/mirror/orders?id=7", "payload", "yes"}
+		if m != want {
+			t.Errorf("shadow got %+v, want %+v", m, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("shadow never received the mirrored request")
+	}
+}
+
+func TestWithShadowUnreachable(t *testing.T) {
+	h := withShadow("http://127.0.0.1:1")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+		t.Errorf("client got %d %q, want 200 ok", rec.Code, rec.Body)
+	}
+}
+
+func TestWithHTTPSRedirect(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		target       string
+		tls          bool
+		forwarded    string
+		wantStatus   int
+		wantLocation string
+	}{
+		{"plain HTTP", http.MethodGet, "http://example.com/a/b?q=1", false, "", http.StatusMovedPermanently, "https://example.com/a/b?q=1"},
+		{"plain HTTP POST", http.MethodPost, "http://example.com/form", false, "", http.StatusPermanentRedirect, "https://example.com/form"},
+		{"forwarded http", http.MethodGet, "http://example.com/", false, "http", http.StatusMovedPermanently, "https://example.com/"},
+		{"TLS", http.MethodGet, "https://example.com/", true, "", http.StatusOK, ""},
+		{"forwarded https", http.MethodGet, "http://example.com/", false, "HTTPS", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := withHTTPSRedirect()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if !tt.tls {
+				req.TLS = nil
+			}
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.forwarded)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestWithConcurrencyLimit(t *testing.T) {
+	const max = 3
+	release := make(chan struct{})
+	var entered sync.WaitGroup
+	entered.Add(max)
+	h := withConcurrencyLimit(max)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			entered.Done()
+			<-release
+		}
+	}))
+
+	finished := make(chan struct{}, max)
+	for range max {
+		go func() {
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+			finished <- struct{}{}
+		}()
+	}
+	entered.Wait()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status with %d in flight = %d, want %d", max, rec.Code, http.StatusServiceUnavailable)
+	}
+
+	// Finishing one slow request frees exactly one slot.
+	release <- struct{}{}
+	<-finished
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status after one completed = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	close(release)
+	for range max - 1 {
+		<-finished
+	}
+}
+
+func TestWithLatencyHistogram(t *testing.T) {
+	mw, snapshot := withLatencyHistogram([]time.Duration{200 * time.Millisecond, 20 * time.Millisecond})
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d, _ := time.ParseDuration(r.URL.Query().Get("sleep"))
+		time.Sleep(d)
+	}))
+	for _, sleep := range []string{"0s", "0s", "60ms", "300ms"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?sleep="+sleep, nil))
+	}
+
+	want := map[time.Duration]int{
+		20 * time.Millisecond:  2,
+		200 * time.Millisecond: 1,
+		overflowBucket:         1,
+	}
+	got := snapshot()
+	if !maps.Equal(got, want) {
+		t.Errorf("counts = %v, want %v", got, want)
+	}
+
+	// The snapshot is a copy.
+	got[overflowBucket] = 100
+	if snapshot()[overflowBucket] != 1 {
+		t.Error("mutating the snapshot changed the histogram")
+	}
+}
+
+func TestWithCSRF(t *testing.T) {
+	secret := []byte("csrf-secret")
+	valid := signBody([]byte("sess-1"), secret)
+	tests := []struct {
+		name       string
+		method     string
+		session    string
+		token      string
+		wantStatus int
+		wantToken  string
+	}{
+		{"valid token", http.MethodPost, "sess-1", valid, http.StatusOK, ""},
+		{"valid token DELETE", http.MethodDelete, "sess-1", valid, http.StatusOK, ""},
+		{"invalid token", http.MethodPost, "sess-1", "bogus", http.StatusForbidden, ""},
+		{"token for another session", http.MethodPut, "sess-2", valid, http.StatusForbidden, ""},
+		{"no session cookie", http.MethodPost, "", valid, http.StatusForbidden, ""},
+		{"safe method gets token", http.MethodGet, "sess-1", "", http.StatusOK, valid},
+		{"safe method without session", http.MethodGet, "", "", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ran := false
+			h := withCSRF(secret)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { ran = true }))
+			req := httptest.NewRequest(tt.method, "/", nil)
+			if tt.session != "" {
+				req.AddCookie(&http.Cookie{Name: sessionCookie, Value: tt.session})
+			}
+			if tt.token != "" {
+				req.Header.Set(csrfHeader, tt.token)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if ran != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("handler ran = %v, want %v", ran, !ran)
+			}
+			if got := rec.Header().Get(csrfHeader); got != tt.wantToken {
+				t.Errorf("%s response header = %q, want %q", csrfHeader, got, tt.wantToken)
+			}
+		})
+	}
+}
+
+// failingWriter is an io.Writer that always errors.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("archive unavailable") }
+
+func TestWithResponseTee(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		status int
+	}{
+		{"single write", []string{"hello"}, http.StatusOK},
+		{"several writes", []string{"a", "bb", "ccc"}, http.StatusCreated},
+		{"error status", []string{"not found"}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var archive bytes.Buffer
+			h := withResponseTee(&archive)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Test", "kept")
+				w.WriteHeader(tt.status)
+				for _, c := range tt.chunks {
+					w.Write([]byte(c))
+				}
+			}))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			want := strings.Join(tt.chunks, "")
+			if rec.Body.String() != want || archive.String() != want {
+				t.Errorf("client got %q, tee got %q, want both %q", rec.Body, archive.String(), want)
+			}
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("X-Test"); got != "kept" {
+				t.Errorf("X-Test = %q, want %q", got, "kept")
+			}
+		})
+	}
+
+	t.Run("tee error does not affect client", func(t *testing.T) {
+		h := withResponseTee(failingWriter{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if n, err := w.Write([]byte("still here")); n != 10 || err != nil {
+				t.Errorf("Write = %d, %v, want 10, nil", n, err)
+			}
+		}))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Body.String() != "still here" {
+			t.Errorf("body = %q, want %q", rec.Body, "still here")
+		}
+	})
+}
+
+func TestWithContentType(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		body        string
+		contentType string
+		wantStatus  int
+	}{
+		{"allowed", http.MethodPost, `{}`, "application/json", http.StatusOK},
+		{"allowed with charset", http.MethodPut, `{}`, "application/json; charset=utf-8", http.StatusOK},
+		{"case insensitive", http.MethodPost, `{}`, "Application/JSON", http.StatusOK},
+		{"disallowed", http.MethodPost, `a=1`, "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"missing", http.MethodPost, `{}`, "", http.StatusUnsupportedMediaType},
+		{"malformed", http.MethodPost, `{}`, "application/", http.StatusUnsupportedMediaType},
+		{"bodyless GET", http.MethodGet, "", "", http.StatusOK},
+		{"bodyless DELETE", http.MethodDelete, "", "text/html", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := withContentType("application/json", "text/plain")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			var body io.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+			req := httptest.NewRequest(tt.method, "/", body)
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// gzipped returns s compressed with gzip.
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestWithGzipRequest(t *testing.T) {
+	const payload = `{"name":"alice"}`
+	compressed := gzipped(t, payload)
+	tests := []struct {
+		name       string
+		body       []byte
+		encoding   string
+		wantStatus int
+		wantBody   string
+		wantErr    bool
+	}{
+		{"gzip body", compressed, "gzip", http.StatusOK, payload, false},
+		{"encoding case insensitive", compressed, "GZIP", http.StatusOK, payload, false},
+		{"plain body untouched", []byte(payload), "", http.StatusOK, payload, false},
+		{"corrupt header", []byte("not gzip at all"), "gzip", http.StatusBadRequest, "", false},
+		{"truncated stream", compressed[:len(compressed)-6], "gzip", http.StatusOK, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			var readErr error
+			ran := false
+			h := withGzipRequest()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ran = true
+				if enc := r.Header.Get("Content-Encoding"); enc != "" {
+					t.Errorf("handler saw Content-Encoding %q", enc)
+				}
+				var b []byte
+				b, readErr = io.ReadAll(r.Body)
+				got = string(b)
+			}))
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(tt.body))
+			if tt.encoding != "" {
+				req.Header.Set("Content-Encoding", tt.encoding)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if ran {
+					t.Error("handler ran for a corrupt body")
+				}
+				return
+			}
+			if (readErr != nil) != tt.wantErr {
+				t.Errorf("read error = %v, want error %v", readErr, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.wantBody {
+				t.Errorf("handler read %q, want %q", got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestWithServerTiming(t *testing.T) {
+	tests := []struct {
+		name    string
+		sleep   time.Duration
+		status  int
+		minimum float64
+	}{
+		{"fast", 0, http.StatusOK, 0},
+		{"slow", 20 * time.Millisecond, http.StatusCreated, 20},
+	}
+	re := regexp.MustCompile(`^app;dur=(\d+\.\d)$`)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := withServerTiming()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(tt.sleep)
+				w.WriteHeader(tt.status)
+				w.Write([]byte("body"))
+			}))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			m := re.FindStringSubmatch(rec.Header().Get("Server-Timing"))
+			if m == nil {
+				t.Fatalf("Server-Timing = %q, want app;dur=<ms>", rec.Header().Get("Server-Timing"))
+			}
+			dur, err := strconv.ParseFloat(m[1], 64)
+			if err != nil || dur < tt.minimum {
+				t.Errorf("dur = %s, want a duration of at least %vms", m[1], tt.minimum)
+			}
+			if rec.Code != tt.status || rec.Body.String() != "body" {
+				t.Errorf("response = %d %q, want %d %q", rec.Code, rec.Body, tt.status, "body")
+			}
+		})
+	}
+}
+
+func TestWithCacheControl(t *testing.T) {
+	rules := map[string]string{
+		"/static/*":        "public, max-age=3600",
+		"/static/*.html":   "no-cache",
+		"/static/logo.png": "public, max-age=31536000, immutable",
+	}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/static/app.js", "public, max-age=3600"},
+		{"/static/index.html", "no-cache"},
+		{"/static/logo.png", "public, max-age=31536000, immutable"},
+		{"/static/img/a.png", ""}, // * does not cross /
+		{"/api/users", ""},
+	}
+	h := withCacheControl(rules)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if got := rec.Header().Get("Cache-Control"); got != tt.want {
+				t.Errorf("Cache-Control = %q, want %q", got, tt.want)
+			}
+			if _, ok := rec.Header()["Cache-Control"]; ok != (tt.want != "") {
+				t.Errorf("Cache-Control present = %v, want %v", ok, tt.want != "")
+			}
+		})
+	}
+}
+
+func TestWithPerIPRateLimit(t *testing.T) {
+	h := withPerIPRateLimit(2)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	do := func(remote string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	steps := []struct {
+		remote string
+		want   int
+	}{
+		{"10.0.0.1:1111", http.StatusOK},
+		{"10.0.0.1:2222", http.StatusOK}, // same IP, different port
+		{"10.0.0.1:3333", http.StatusTooManyRequests},
+		{"10.0.0.2:1111", http.StatusOK}, // another client is unaffected
+		{"10.0.0.2:1111", http.StatusOK},
+		{"10.0.0.2:1111", http.StatusTooManyRequests},
+	}
+	for i, s := range steps {
+		if got := do(s.remote); got != s.want {
+			t.Errorf("request %d from %s: status = %d, want %d", i+1, s.remote, got, s.want)
+		}
+	}
+}
+
+func TestWithPerIPRateLimitForwardedFor(t *testing.T) {
+	tests := []struct {
+		name  string
+		trust bool
+		want  []int
+	}{
+		// Two clients behind one proxy: separate buckets only if trusted.
+		{"trusted", true, []int{http.StatusOK, http.StatusOK}},
+		{"untrusted", false, []int{http.StatusOK, http.StatusTooManyRequests}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := withPerIPRateLimit(1, tt.trust)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			for i, xff := range []string{"203.0.113.1", "203.0.113.2, 10.0.0.9"} {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.RemoteAddr = "10.0.0.9:443"
+				req.Header.Set("X-Forwarded-For", xff)
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				if rec.Code != tt.want[i] {
+					t.Errorf("request %d (X-Forwarded-For %q): status = %d, want %d", i+1, xff, rec.Code, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name   string
+		remote string
+		xff    string
+		trust  bool
+		want   string
+	}{
+		{"host and port", "192.0.2.1:1234", "", false, "192.0.2.1"},
+		{"IPv6", "[2001:db8::1]:443", "", false, "2001:db8::1"},
+		{"no port", "192.0.2.1", "", false, "192.0.2.1"},
+		{"forwarded untrusted", "192.0.2.1:1234", "198.51.100.7", false, "192.0.2.1"},
+		{"forwarded trusted", "192.0.2.1:1234", " 198.51.100.7 , 10.0.0.1", true, "198.51.100.7"},
+		{"empty first forwarded entry", "192.0.2.1:1234", " , 10.0.0.1", true, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remote
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if got := clientIP(req, tt.trust); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithErrorCapture(t *testing.T) {
+	mw, records := withErrorCapture(3)
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code, _ := strconv.Atoi(r.URL.Query().Get("status"))
+		if r.URL.Path == "/panic" {
+			// What withRecovery does for a panicking handler.
+			code = http.StatusInternalServerError
+		}
+		w.WriteHeader(code)
+	}))
+
+	before := time.Now()
+	for _, req := range []struct{ method, target string }{
+		{http.MethodGet, "/a?status=200"},
+		{http.MethodPost, "/b?status=500"},
+		{http.MethodGet, "/c?status=404"},
+		{http.MethodPut, "/d?status=503"},
+		{http.MethodGet, "/e?status=502"},
+		{http.MethodDelete, "/panic"},
+	} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(req.method, req.target, nil))
+	}
+
+	// Only 5xx is kept, the oldest (/b) was overwritten, and the rest come
+	// back oldest first.
+	want := []ErrorRecord{
+		{Method: http.MethodPut, Path: "/d", Status: http.StatusServiceUnavailable},
+		{Method: http.MethodGet, Path: "/e", Status: http.StatusBadGateway},
+		{Method: http.MethodDelete, Path: "/panic", Status: http.StatusInternalServerError},
+	}
+	got := records()
+	if len(got) != len(want) {
+		t.Fatalf("got %d records, want %d: %+v", len(got), len(want), got)
+	}
+	for i, g := range got {
+		if g.Method != want[i].Method || g.Path != want[i].Path || g.Status != want[i].Status {
+			t.Errorf("record %d = %+v, want %+v", i, g, want[i])
+		}
+		if g.Time.Before(before) || g.Time.After(time.Now()) {
+			t.Errorf("record %d time %v outside the test run", i, g.Time)
+		}
+	}
+}
+
+func TestWithErrorCaptureConcurrent(t *testing.T) {
+	mw, records := withErrorCapture(10)
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+			records()
+		}()
+	}
+	wg.Wait()
+	if got := len(records()); got != 10 {
+		t.Errorf("got %d records, want 10", got)
+	}
+}
+
+func TestWithHeaderLimits(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxHeader  int
+		maxURL     int
+		target     string
+		headers    map[string]string
+		wantStatus int
+	}{
+		{"normal request", 100, 50, "/items?page=2", map[string]string{"Accept": "text/html"}, http.StatusOK},
+		{"URL at limit", 100, 13, "/items?page=2", nil, http.StatusOK},
+		{"oversized URL", 100, 12, "/items?page=2", nil, http.StatusRequestHeaderFieldsTooLarge},
+		{"headers at limit", 16, 0, "/", map[string]string{"X-Long": "0123456789"}, http.StatusOK}, // 6 + 10
+		{"oversized headers", 15, 0, "/", map[string]string{"X-Long": "0123456789"}, http.StatusRequestHeaderFieldsTooLarge},
+		{"headers summed", 20, 0, "/", map[string]string{"X-A": "0123456789", "X-B": "0123456789"}, http.StatusRequestHeaderFieldsTooLarge},
+		{"limits disabled", 0, 0, "/" + strings.Repeat("a", 5000), map[string]string{"X-Big": strings.Repeat("b", 5000)}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := withHeaderLimits(tt.maxHeader, tt.maxURL)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestWithResponseSignature(t *testing.T) {
+	secret := []byte("signing-key")
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"ok", `{"balance":100}`, http.StatusOK},
+		{"empty body", "", http.StatusNoContent},
+		{"error status", "not found\n", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := withResponseSignature(secret)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			}))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if rec.Code != tt.status || rec.Body.String() != tt.body {
+				t.Errorf("response = %d %q, want %d %q", rec.Code, rec.Body, tt.status, tt.body)
+			}
+			sig := rec.Header().Get(signatureHeader)
+			if want := signBody([]byte(tt.body), secret); sig != want {
+				t.Errorf("%s = %q, want %q", signatureHeader, sig, want)
+			}
+			if !verifySignature(rec.Body.Bytes(), sig, secret) {
+				t.Error("verifySignature rejected the response it was sent with")
+			}
+			if verifySignature([]byte(tt.body+"x"), sig, secret) {
+				t.Error("verifySignature accepted a tampered body")
+			}
+			if verifySignature(rec.Body.Bytes(), sig, []byte("other-key")) {
+				t.Error("verifySignature accepted the wrong secret")
+			}
+		})
+	}
+}
+
+func TestWithCleanPath(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		target       string
+		wantStatus   int
+		wantLocation string
+	}{
+		{"duplicate slashes", http.MethodGet, "/a//b///c", http.StatusMovedPermanently, "/a/b/c"},
+		{"dot segments", http.MethodGet, "/a/./b/../c", http.StatusMovedPermanently, "/a/c"},
+		{"keeps query and trailing slash", http.MethodGet, "/a//b/?q=1", http.StatusMovedPermanently, "/a/b/?q=1"},
+		{"POST keeps method", http.MethodPost, "/a//b", http.StatusPermanentRedirect, "/a/b"},
+		{"stays on this host", http.MethodGet, "//evil.com/x", http.StatusMovedPermanently, "/evil.com/x"},
+		{"dotdot above root", http.MethodGet, "/../../etc", http.StatusMovedPermanently, "/etc"},
+		{"clean path", http.MethodGet, "/a/b/c", http.StatusOK, ""},
+		{"clean with trailing slash", http.MethodGet, "/a/b/", http.StatusOK, ""},
+		{"root", http.MethodGet, "/", http.StatusOK, ""},
+		{"root duplicates", http.MethodGet, "///", http.StatusMovedPermanently, "/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			h := withCleanPath()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { seen = r.URL.Path }))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+			if tt.wantStatus == http.StatusOK && seen != tt.target {
+				t.Errorf("handler saw %q, want %q unchanged", seen, tt.target)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// inboundTransport ties each outbound request to the lifetime of the
+// inbound request it was made for: whichever context ends first cancels
+// the call.
+type inboundTransport struct {
+	base    http.RoundTripper
+	inbound context.Context
+}
+
+// cancelOnClose releases the merged context once the body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel func()
+}
+
+func (b cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+func (t inboundTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	stop := context.AfterFunc(t.inbound, cancel)
+	release := func() {
+		stop()
+		cancel()
+	}
+
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = cancelOnClose{ReadCloser: resp.Body, cancel: release}
+	return resp, nil
+}
+
+type clientKey struct{}
+
+// withOutboundClient stores a copy of client in the request context for
+// ClientFromContext. Requests made with that copy are cancelled when the
+// inbound request is cancelled or hits its deadline, so downstream calls
+// never outlive the request that triggered them.
+func withOutboundClient(client *http.Client) func(http.Handler) http.Handler {
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			bound := *client
+			bound.Transport = inboundTransport{base: base, inbound: r.Context()}
+			ctx := context.WithValue(r.Context(), clientKey{}, &bound)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ClientFromContext returns the client stored by withOutboundClient, if
+// any.
+func ClientFromContext(ctx context.Context) (*http.Client, bool) {
+	c, ok := ctx.Value(clientKey{}).(*http.Client)
+	return c, ok
+}
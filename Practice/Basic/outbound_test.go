@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWithOutboundClientCancels(t *testing.T) {
+	entered, downstreamGone := make(chan struct{}), make(chan struct{})
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-r.Context().Done()
+		close(downstreamGone)
+	}))
+	defer downstream.Close()
+
+	outboundErr := make(chan error, 1)
+	h := withOutboundClient(downstream.Client())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, ok := ClientFromContext(r.Context())
+		if !ok {
+			outboundErr <- errors.New("no client in context")
+			return
+		}
+		// No context on the outbound request: the client alone must tie
+		// it to the inbound one.
+		resp, err := client.Get(downstream.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		outboundErr <- err
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	<-entered
+	cancel()
+
+	select {
+	case err := <-outboundErr:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("outbound error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("outbound request not cancelled with the inbound one")
+	}
+	select {
+	case <-downstreamGone:
+	case <-time.After(5 * time.Second):
+		t.Error("downstream never saw the cancellation")
+	}
+}
+
+func TestWithOutboundClientSuccess(t *testing.T) {
+	downstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("pong"))
+	}))
+	defer downstream.Close()
+
+	orig := downstream.Client()
+	origTransport := orig.Transport
+	var body string
+	h := withOutboundClient(orig)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, _ := ClientFromContext(r.Context())
+		resp, err := client.Get(downstream.URL)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		body = string(b)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if body != "pong" {
+		t.Errorf("downstream body = %q, want %q", body, "pong")
+	}
+	if orig.Transport != origTransport {
+		t.Error("withOutboundClient modified the caller's client")
+	}
+	if _, ok := ClientFromContext(context.Background()); ok {
+		t.Error("ClientFromContext found a client in a bare context")
+	}
+}
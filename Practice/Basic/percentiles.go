@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// reservoirSize caps how many latency samples withLatencyPercentiles
+// keeps per path.
+const reservoirSize = 1024
+
+// maxPercentilePaths caps how many distinct paths withLatencyPercentiles
+// tracks; like withMetrics, later new paths share percentileOverflowPath.
+const maxPercentilePaths = 1000
+
+// percentileOverflowPath collects the samples of paths seen once
+// maxPercentilePaths paths are tracked.
+const percentileOverflowPath = "other"
+
+// reservoir is a uniform random sample (Algorithm R) of a stream.
+type reservoir struct {
+	samples []time.Duration
+	seen    int
+}
+
+func (r *reservoir) add(d time.Duration) {
+	r.seen++
+	if len(r.samples) < reservoirSize {
+		r.samples = append(r.samples, d)
+		return
+	}
+	if i := rand.IntN(r.seen); i < reservoirSize {
+		r.samples[i] = d
+	}
+}
+
+// withLatencyPercentiles returns a middleware that samples request
+// latency per path, keeping at most reservoirSize samples each, and a
+// function estimating the p50 and p95 latency for a path from those
+// samples. Paths with no samples report zero. Once maxPercentilePaths
+// paths are tracked, new ones are sampled under percentileOverflowPath.
+func withLatencyPercentiles() (func(http.Handler) http.Handler, func(path string) (p50, p95 time.Duration)) {
+	var mu sync.Mutex
+	byPath := make(map[string]*reservoir)
+
+	mw := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			next.ServeHTTP(w, r)
+			elapsed := time.Since(start)
+
+			mu.Lock()
+			key := r.URL.Path
+			if _, ok := byPath[key]; !ok && len(byPath) >= maxPercentilePaths {
+				key = percentileOverflowPath
+			}
+			res, ok := byPath[key]
+			if !ok {
+				res = &reservoir{}
+				byPath[key] = res
+			}
+			res.add(elapsed)
+			mu.Unlock()
+		})
+	}
+
+	percentiles := func(path string) (p50, p95 time.Duration) {
+		mu.Lock()
+		res, ok := byPath[path]
+		var samples []time.Duration
+		if ok {
+			samples = slices.Clone(res.samples)
+		}
+		mu.Unlock()
+
+		if len(samples) == 0 {
+			return 0, 0
+		}
+		slices.Sort(samples)
+		return percentile(samples, 50), percentile(samples, 95)
+	}
+
+	return mw, percentiles
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100 // ceil(p/100 * n)
+	return sorted[max(rank, 1)-1]
+}
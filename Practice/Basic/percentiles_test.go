@@ -0,0 +1,118 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	sorted := make([]time.Duration, 100)
+	for i := range sorted {
+		sorted[i] = time.Duration(i+1) * time.Millisecond
+	}
+	tests := []struct {
+		name   string
+		sorted []time.Duration
+		p      int
+		want   time.Duration
+	}{
+		{"p50 of 1..100", sorted, 50, 50 * time.Millisecond},
+		{"p95 of 1..100", sorted, 95, 95 * time.Millisecond},
+		{"p100", sorted, 100, 100 * time.Millisecond},
+		{"p0 is the minimum", sorted, 0, time.Millisecond},
+		{"single sample", sorted[:1], 95, time.Millisecond},
+		{"rounds rank up", sorted[:3], 50, 2 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentile(tt.sorted, tt.p); got != tt.want {
+				t.Errorf("percentile(p%d) = %v, want %v", tt.p, got, tt.want)
+			}
+		})
+	}
+}
+
+// within reports whether got is within frac of want.
+func within(got, want time.Duration, frac float64) bool {
+	return math.Abs(float64(got-want)) <= frac*float64(want)
+}
+
+func TestReservoirPercentiles(t *testing.T) {
+	// A uniform 1..10000ms stream is ten times the reservoir, so the
+	// estimate comes from a random subsample.
+	var r reservoir
+	for i := 1; i <= 10*reservoirSize; i++ {
+		r.add(time.Duration(i) * time.Millisecond)
+	}
+	if len(r.samples) != reservoirSize {
+		t.Fatalf("kept %d samples, want %d", len(r.samples), reservoirSize)
+	}
+	sorted := slices.Sorted(slices.Values(r.samples))
+	n := time.Duration(10*reservoirSize) * time.Millisecond
+	if p50 := percentile(sorted, 50); !within(p50, n/2, 0.1) {
+		t.Errorf("p50 = %v, want %v ± 10%%", p50, n/2)
+	}
+	if p95 := percentile(sorted, 95); !within(p95, n*95/100, 0.04) {
+		t.Errorf("p95 = %v, want %v ± 4%%", p95, n*95/100)
+	}
+}
+
+func TestWithLatencyPercentiles(t *testing.T) {
+	mw, percentiles := withLatencyPercentiles()
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d, _ := time.ParseDuration(r.URL.Query().Get("sleep"))
+		time.Sleep(d)
+	}))
+	// /mixed: 18 fast requests and 2 slow ones, so p50 is fast and p95
+	// (the 19th of 20) is slow.
+	for i := range 20 {
+		sleep := "0s"
+		if i%10 == 0 {
+			sleep = "40ms"
+		}
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/mixed?sleep="+sleep, nil))
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow?sleep=20ms", nil))
+
+	if p50, p95 := percentiles("/mixed"); p50 >= 10*time.Millisecond || p95 < 40*time.Millisecond {
+		t.Errorf("/mixed p50, p95 = %v, %v; want p50 < 10ms and p95 >= 40ms", p50, p95)
+	}
+	if p50, p95 := percentiles("/slow"); p50 < 20*time.Millisecond || p95 != p50 {
+		t.Errorf("/slow p50, p95 = %v, %v; want both the single 20ms+ sample", p50, p95)
+	}
+	if p50, p95 := percentiles("/never"); p50 != 0 || p95 != 0 {
+		t.Errorf("unseen path = %v, %v, want 0, 0", p50, p95)
+	}
+}
+
+func TestWithLatencyPercentilesBoundsPaths(t *testing.T) {
+	mw, percentiles := withLatencyPercentiles()
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	const extra = 50
+	for i := range maxPercentilePaths + extra {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/p"+strconv.Itoa(i), nil))
+	}
+	// A path tracked before the cap keeps its own samples.
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/p0", nil))
+
+	// Paths past the cap have no samples of their own; they went to the
+	// overflow entry instead.
+	for _, path := range []string{"/p" + strconv.Itoa(maxPercentilePaths), "/p" + strconv.Itoa(maxPercentilePaths+extra-1)} {
+		if p50, p95 := percentiles(path); p50 != 0 || p95 != 0 {
+			t.Errorf("%s past the cap = %v, %v, want 0, 0", path, p50, p95)
+		}
+	}
+	if _, p95 := percentiles(percentileOverflowPath); p95 == 0 {
+		t.Errorf("%s has no samples, want the %d paths past the cap", percentileOverflowPath, extra)
+	}
+	for _, path := range []string{"/p0", "/p" + strconv.Itoa(maxPercentilePaths-1)} {
+		if _, p95 := percentiles(path); p95 == 0 {
+			t.Errorf("%s tracked before the cap has no samples", path)
+		}
+	}
+}
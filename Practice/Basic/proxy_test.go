@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewProxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Backend", "yes")
+		w.Write([]byte("backend saw " + r.URL.Path + " from " + r.Header.Get("X-Forwarded-Host")))
+	}))
+	defer backend.Close()
+
+	proxy, err := newProxy(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	front := httptest.NewServer(proxy)
+	defer front.Close()
+
+	resp, err := http.Get(front.URL + "/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	want := "backend saw /hello from " + front.Listener.Addr().String()
+	if resp.StatusCode != http.StatusOK || string(body) != want {
+		t.Errorf("response = %d %q, want 200 %q", resp.StatusCode, body, want)
+	}
+	if resp.Header.Get("X-Backend") != "yes" {
+		t.Error("backend header was not relayed")
+	}
+}
+
+func TestNewProxyInvalidTarget(t *testing.T) {
+	for _, target := range []string{"", "localhost:8080", "ftp://example.com", "http://", "://bad"} {
+		if _, err := newProxy(target); err == nil {
+			t.Errorf("newProxy(%q) succeeded, want an error", target)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+)
+
+type queryKey[T any] struct{}
+
+// withQueryParams decodes the URL query into a T, which must be a struct,
+// and stores it in the request context for QueryFromContext. Fields are
+// matched by their `query:"name"` tag; a `default:"value"` tag supplies
+// the value when the parameter is absent. int, string and bool fields are
+// supported. A value that fails to convert gets a 400 HTTPError body.
+//
+//	type listParams struct {
+//		Page    int    `query:"page" default:"1"`
+//		Sort    string `query:"sort"`
+//		Reverse bool   `query:"reverse"`
+//	}
+func withQueryParams[T any]() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var params T
+			if err := decodeQuery(r.URL.Query(), &params); err != nil {
+				writeJSON(w, http.StatusBadRequest, &HTTPError{Code: http.StatusBadRequest, Message: err.Error()})
+				return
+			}
+			ctx := context.WithValue(r.Context(), queryKey[T]{}, params)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// QueryFromContext returns the parameters decoded by withQueryParams[T].
+func QueryFromContext[T any](ctx context.Context) (T, bool) {
+	params, ok := ctx.Value(queryKey[T]{}).(T)
+	return params, ok
+}
+
+// decodeQuery fills the tagged fields of the struct dst points to from q.
+func decodeQuery(q url.Values, dst any) error {
+	v := reflect.ValueOf(dst).Elem()
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("query params: %s is not a struct", v.Type())
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, ok := field.Tag.Lookup("query")
+		if !ok || !field.IsExported() {
+			continue
+		}
+		raw, present := q.Get(name), q.Has(name)
+		if !present {
+			def, hasDefault := field.Tag.Lookup("default")
+			if !hasDefault {
+				continue
+			}
+			raw = def
+		}
+		if err := setField(v.Field(i), raw); err != nil {
+			return fmt.Errorf("query parameter %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// setField parses raw into f according to f's kind.
+func setField(f reflect.Value, raw string) error {
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(raw)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, f.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		f.SetInt(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		f.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported field type %s", f.Type())
+	}
+	return nil
+}
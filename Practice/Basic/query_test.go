@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type listParams struct {
+	Page    int    `query:"page" default:"1"`
+	Sort    string `query:"sort"`
+	Reverse bool   `query:"reverse"`
+	Ignored string
+}
+
+func TestWithQueryParams(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		want       listParams
+		wantErr    string
+	}{
+		{"all params", "?page=3&sort=name&reverse=true", http.StatusOK, listParams{Page: 3, Sort: "name", Reverse: true}, ""},
+		{"defaults for missing", "", http.StatusOK, listParams{Page: 1}, ""},
+		{"empty value overrides default", "?page=", http.StatusBadRequest, listParams{}, `query parameter "page": invalid integer ""`},
+		{"bad integer", "?page=two", http.StatusBadRequest, listParams{}, `query parameter "page": invalid integer "two"`},
+		{"bad boolean", "?reverse=maybe", http.StatusBadRequest, listParams{}, `query parameter "reverse": invalid boolean "maybe"`},
+		{"untagged field is not decoded", "?Ignored=x&sort=id", http.StatusOK, listParams{Page: 1, Sort: "id"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got listParams
+			var ok bool
+			h := withQueryParams[listParams]()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, ok = QueryFromContext[listParams](r.Context())
+			}))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/items"+tt.query, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				var he HTTPError
+				if err := json.Unmarshal(rec.Body.Bytes(), &he); err != nil || !strings.Contains(he.Message, tt.wantErr) {
+					t.Errorf("body = %s, want message containing %q", rec.Body, tt.wantErr)
+				}
+				return
+			}
+			if !ok || got != tt.want {
+				t.Errorf("QueryFromContext = %+v, %v; want %+v, true", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithQueryParamsNotAStruct(t *testing.T) {
+	h := withQueryParams[int]()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler ran for a non-struct T")
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import "sync"
+
+// Registry is a map guarded by a sync.RWMutex, safe for concurrent use.
+type Registry[K comparable, V any] struct {
+	mu sync.RWMutex
+	m  map[K]V
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry[K comparable, V any]() *Registry[K, V] {
+	return &Registry[K, V]{m: make(map[K]V)}
+}
+
+// Set stores v under k, replacing any existing value.
+func (r *Registry[K, V]) Set(k K, v V) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.m[k] = v
+}
+
+// Get returns the value stored under k and whether it was present.
+func (r *Registry[K, V]) Get(k K) (V, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	v, ok := r.m[k]
+	return v, ok
+}
+
+// Delete removes k. Deleting a missing key is a no-op.
+func (r *Registry[K, V]) Delete(k K) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.m, k)
+}
+
+// Len returns the number of entries.
+func (r *Registry[K, V]) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.m)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry[string, int]()
+	r.Set("a", 1)
+	r.Set("b", 2)
+	r.Set("a", 3)
+	r.Delete("b")
+	r.Delete("missing")
+
+	if v, ok := r.Get("a"); !ok || v != 3 {
+		t.Errorf("Get(a) = %d, %v; want 3, true", v, ok)
+	}
+	if _, ok := r.Get("b"); ok {
+		t.Error("Get(b) found a deleted key")
+	}
+	if n := r.Len(); n != 1 {
+		t.Errorf("Len() = %d, want 1", n)
+	}
+}
+
+func TestRegistryConcurrent(t *testing.T) {
+	const n = 100
+	r := NewRegistry[int, int]()
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.Set(i, i*i)
+			r.Get(i)
+			// Odd keys are removed again.
+			if i%2 == 1 {
+				r.Delete(i)
+			}
+			r.Len()
+		}()
+	}
+	wg.Wait()
+
+	if got := r.Len(); got != n/2 {
+		t.Errorf("Len() = %d, want %d", got, n/2)
+	}
+	for i := range n {
+		v, ok := r.Get(i)
+		if wantOK := i%2 == 0; ok != wantOK || (ok && v != i*i) {
+			t.Errorf("Get(%d) = %d, %v; want %d, %v", i, v, ok, i*i, wantOK)
+		}
+	}
+}
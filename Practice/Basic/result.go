@@ -0,0 +1,59 @@
+package main
+
+import "context"
+
+// Result holds either a value or the error that prevented producing one.
+// ResultPool and the error-reporting button handlers return it.
+type Result[T any] struct {
+	value T
+	err   error
+}
+
+// Ok returns a successful Result holding v.
+func Ok[T any](v T) Result[T] {
+	return Result[T]{value: v}
+}
+
+// Err returns a failed Result holding err.
+func Err[T any](err error) Result[T] {
+	return Result[T]{err: err}
+}
+
+// resultOf turns a (value, error) pair into a Result: Err if err is
+// non-nil, Ok otherwise.
+func resultOf[T any](v T, err error) Result[T] {
+	if err != nil {
+		return Err[T](err)
+	}
+	return Ok(v)
+}
+
+// Unwrap returns the value and error, so callers can use the usual
+// "if err != nil" and errors.Is/As checks.
+func (r Result[T]) Unwrap() (T, error) {
+	return r.value, r.err
+}
+
+// IsErr reports whether r holds an error.
+func (r Result[T]) IsErr() bool {
+	return r.err != nil
+}
+
+// errOnly adapts a Result-returning func, e.g. a createButtonHandlerE
+// handler, to the func() error shape withRetry, fanOutConcurrent and
+// validateThen take. The value is dropped.
+func errOnly[T any](fn func() Result[T]) func() error {
+	return func() error {
+		_, err := fn().Unwrap()
+		return err
+	}
+}
+
+// errOnlyCtx is errOnly for context-taking funcs such as a
+// createButtonHandlerCtx handler, so they can be served with fromTask.
+func errOnlyCtx[T any](fn func(context.Context) Result[T]) func(context.Context) error {
+	return func(ctx context.Context) error {
+		_, err := fn(ctx).Unwrap()
+		return err
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"testing"
+)
+
+func TestResult(t *testing.T) {
+	errBoom := errors.New("boom")
+	tests := []struct {
+		name    string
+		r       Result[int]
+		want    int
+		wantErr error
+	}{
+		{"Ok", Ok(7), 7, nil},
+		{"Ok zero value", Ok(0), 0, nil},
+		{"Err", Err[int](errBoom), 0, errBoom},
+		{"resultOf value", resultOf(3, nil), 3, nil},
+		{"resultOf error drops value", resultOf(3, errBoom), 0, errBoom},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.r.Unwrap()
+			if got != tt.want || err != tt.wantErr {
+				t.Errorf("Unwrap() = %d, %v, want %d, %v", got, err, tt.want, tt.wantErr)
+			}
+			if tt.r.IsErr() != (tt.wantErr != nil) {
+				t.Errorf("IsErr() = %v, want %v", tt.r.IsErr(), tt.wantErr != nil)
+			}
+		})
+	}
+}
+
+func TestResultErrorsInterop(t *testing.T) {
+	wrapped := fmt.Errorf("load config: %w", fs.ErrNotExist)
+	r := Err[string](wrapped)
+
+	_, err := r.Unwrap()
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("errors.Is(%v, fs.ErrNotExist) = false, want true", err)
+	}
+	if errors.Is(err, fs.ErrPermission) {
+		t.Errorf("errors.Is(%v, fs.ErrPermission) = true, want false", err)
+	}
+
+	// Errors keep their type through a Result, so errors.As works too.
+	_, err = Err[int](fmt.Errorf("lookup: %w", NotFound("no such user"))).Unwrap()
+	var he *HTTPError
+	if !errors.As(err, &he) || he.Code != http.StatusNotFound {
+		t.Errorf("errors.As(%v, *HTTPError) = %v, want a 404", err, he)
+	}
+
+	// Joined errors from fanOutConcurrent match each cause.
+	errA, errB := errors.New("a"), errors.New("b")
+	err = fanOutConcurrent(
+		errOnly(func() Result[int] { return Err[int](errA) }),
+		errOnly(func() Result[int] { return Err[int](fmt.Errorf("wrapped: %w", errB)) }),
+	)
+	if !errors.Is(err, errA) || !errors.Is(err, errB) {
+		t.Errorf("joined error %v doesn't match both causes", err)
+	}
+}
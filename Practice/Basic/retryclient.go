@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// retryTransport retries idempotent requests that fail with a transport
+// error or a 5xx response.
+type retryTransport struct {
+	base     http.RoundTripper
+	attempts int
+
+	mu      sync.Mutex
+	backoff func() time.Duration
+}
+
+func (t *retryTransport) nextDelay() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.backoff()
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return t.base.RoundTrip(req)
+	}
+
+	// Retries go out on a clone with a fresh body, since a RoundTripper
+	// must not modify the caller's request.
+	try := req
+	var resp *http.Response
+	var err error
+	for attempt := 1; ; attempt++ {
+		resp, err = t.base.RoundTrip(try)
+		retryable := err != nil || resp.StatusCode >= http.StatusInternalServerError
+		if !retryable || attempt >= t.attempts {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		try = req.Clone(req.Context())
+		if req.GetBody != nil {
+			if try.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+
+		timer := time.NewTimer(t.nextDelay())
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// newRetryClient returns a client that makes up to attempts tries for GET
+// and HEAD requests that hit a connection error or a 5xx response,
+// sleeping for backoff() between tries (e.g. a closure from backoff or
+// backoffJitter). Other methods are never retried since they may not be
+// idempotent. backoff is shared by all requests on the client and is
+// called under a lock.
+func newRetryClient(attempts int, backoff func() time.Duration) *http.Client {
+	return &http.Client{
+		Transport: &retryTransport{
+			base:     http.DefaultTransport,
+			attempts: max(attempts, 1),
+			backoff:  backoff,
+		},
+	}
+}
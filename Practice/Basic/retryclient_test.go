@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyServer answers 503 to the first failures requests and 200 after.
+func flakyServer(t *testing.T, failures int32) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) <= failures {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+func TestRetryClient(t *testing.T) {
+	noDelay := func() time.Duration { return 0 }
+	tests := []struct {
+		name       string
+		method     string
+		failures   int32
+		attempts   int
+		wantStatus int
+		wantHits   int32
+	}{
+		{"GET succeeds after retries", http.MethodGet, 2, 3, http.StatusOK, 3},
+		{"HEAD is retried", http.MethodHead, 1, 3, http.StatusOK, 2},
+		{"GET gives up after attempts", http.MethodGet, 10, 3, http.StatusServiceUnavailable, 3},
+		{"POST is not retried", http.MethodPost, 1, 3, http.StatusServiceUnavailable, 1},
+		{"zero attempts still tries once", http.MethodGet, 1, 0, http.StatusServiceUnavailable, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, hits := flakyServer(t, tt.failures)
+			req, err := http.NewRequest(tt.method, srv.URL, strings.NewReader(""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := newRetryClient(tt.attempts, noDelay).Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := hits.Load(); got != tt.wantHits {
+				t.Errorf("server hits = %d, want %d", got, tt.wantHits)
+			}
+		})
+	}
+}
+
+func TestRetryClientConnectionError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	var delays atomic.Int32
+	client := newRetryClient(3, func() time.Duration {
+		delays.Add(1)
+		return time.Millisecond
+	})
+	if _, err := client.Get(url); err == nil {
+		t.Fatal("Get on a closed server succeeded")
+	}
+	if got := delays.Load(); got != 2 {
+		t.Errorf("backoff called %d times, want 2 (between 3 attempts)", got)
+	}
+}
+
+// recordingTransport records the requests it sends on to base.
+type recordingTransport struct {
+	base http.RoundTripper
+	sent []*http.Request
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.sent = append(t.sent, req)
+	return t.base.RoundTrip(req)
+}
+
+func TestRetryTransportLeavesRequestAlone(t *testing.T) {
+	srv, _ := flakyServer(t, 2)
+	rec := &recordingTransport{base: http.DefaultTransport}
+	rt := &retryTransport{base: rec, attempts: 3, backoff: func() time.Duration { return 0 }}
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, strings.NewReader("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := req.Body
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if req.Body != body {
+		t.Error("RoundTrip replaced the caller's req.Body")
+	}
+	if len(rec.sent) != 3 {
+		t.Fatalf("sent %d requests, want 3", len(rec.sent))
+	}
+	if rec.sent[0] != req {
+		t.Error("first attempt was not the caller's request")
+	}
+	for i, sent := range rec.sent[1:] {
+		if sent == req || sent.Body == body {
+			t.Errorf("retry %d reused the caller's request or body", i+1)
+		}
+	}
+}
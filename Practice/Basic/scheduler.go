@@ -0,0 +1,43 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+)
+
+type scheduledTask struct {
+	priority int
+	task     func()
+}
+
+// Scheduler buffers tasks and runs them by priority when Run is called.
+// The zero value is ready to use and it is safe for concurrent use.
+type Scheduler struct {
+	mu    sync.Mutex
+	tasks []scheduledTask
+}
+
+// Schedule queues task to run on the next Run.
+func (s *Scheduler) Schedule(priority int, task func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tasks = append(s.tasks, scheduledTask{priority: priority, task: task})
+}
+
+// Run executes every queued task, highest priority first and in
+// scheduling order among equal priorities, then empties the queue. Tasks
+// scheduled while Run is executing wait for the next Run.
+func (s *Scheduler) Run() {
+	s.mu.Lock()
+	tasks := s.tasks
+	s.tasks = nil
+	s.mu.Unlock()
+
+	slices.SortStableFunc(tasks, func(a, b scheduledTask) int {
+		return cmp.Compare(b.priority, a.priority)
+	})
+	for _, t := range tasks {
+		t.task()
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSchedulerOrder(t *testing.T) {
+	type task struct {
+		name     string
+		priority int
+	}
+	tests := []struct {
+		name  string
+		tasks []task
+		want  []string
+	}{
+		{"mixed priorities", []task{{"low", 1}, {"high", 10}, {"mid", 5}}, []string{"high", "mid", "low"}},
+		{"ties keep insertion order", []task{{"a", 2}, {"b", 5}, {"c", 2}, {"d", 5}}, []string{"b", "d", "a", "c"}},
+		{"negative priorities last", []task{{"neg", -1}, {"zero", 0}}, []string{"zero", "neg"}},
+		{"nothing scheduled", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s Scheduler
+			var ran []string
+			for _, tk := range tt.tasks {
+				s.Schedule(tk.priority, func() { ran = append(ran, tk.name) })
+			}
+			s.Run()
+			if !slices.Equal(ran, tt.want) {
+				t.Errorf("ran %v, want %v", ran, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchedulerRunEmptiesQueue(t *testing.T) {
+	var s Scheduler
+	var ran []string
+	s.Schedule(1, func() {
+		ran = append(ran, "first")
+		// Scheduled during Run: waits for the next one.
+		s.Schedule(100, func() { ran = append(ran, "nested") })
+	})
+	s.Run()
+	if want := []string{"first"}; !slices.Equal(ran, want) {
+		t.Fatalf("after first Run: ran %v, want %v", ran, want)
+	}
+	s.Run()
+	if want := []string{"first", "nested"}; !slices.Equal(ran, want) {
+		t.Errorf("after second Run: ran %v, want %v", ran, want)
+	}
+	s.Run()
+	if len(ran) != 2 {
+		t.Errorf("third Run re-ran tasks: %v", ran)
+	}
+}
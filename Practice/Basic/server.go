@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// shutdownTimeout bounds how long runServer waits for in-flight requests
+// to finish once a shutdown signal arrives. It is a variable so tests can
+// shorten it.
+var shutdownTimeout = 10 * time.Second
+
+// runServer serves handler on addr until SIGINT or SIGTERM is received,
+// then shuts the server down gracefully. Each onShutdown func runs as soon
+// as shutdown begins, before in-flight requests are drained, e.g. to mark
+// the server not ready. It returns nil on a clean shutdown.
+func runServer(addr string, handler http.Handler, onShutdown ...func()) error {
+	srv := &http.Server{Addr: addr, Handler: handler}
+	return serveUntilSignal(srv, srv.ListenAndServe, onShutdown)
+}
+
+// runServerTLS is runServer over HTTPS using the given certificate and
+// key files.
+func runServerTLS(addr, certFile, keyFile string, handler http.Handler, onShutdown ...func()) error {
+	srv := &http.Server{Addr: addr, Handler: handler}
+	return serveUntilSignal(srv, func() error {
+		return srv.ListenAndServeTLS(certFile, keyFile)
+	}, onShutdown)
+}
+
+// serveUntilSignal runs listen, which must be one of srv's ListenAndServe
+// methods, and shuts srv down gracefully on SIGINT or SIGTERM, waiting up
+// to shutdownTimeout for in-flight requests to drain before running
+// serverHooks. SIGHUP reloads the config file without interrupting the
+// server.
+func serveUntilSignal(srv *http.Server, listen func() error, onShutdown []func()) error {
+	if srv.Handler == nil {
+		srv.Handler = http.DefaultServeMux
+	}
+	inFlight := &requestCounter{}
+	srv.Handler = inFlight.wrap(srv.Handler)
+	runningCounter.Store(inFlight)
+	defer runningCounter.CompareAndSwap(inFlight, nil)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- listen()
+	}()
+
+wait:
+	for {
+		select {
+		case err := <-errCh:
+			// The server failed to start or stopped on its own.
+			return err
+		case <-hup:
+			if err := reloadConfig(); err != nil {
+				log.Printf("Config reload failed: %v", err)
+			} else {
+				log.Printf("Config reloaded from %q", configPath)
+			}
+		case <-ctx.Done():
+			break wait
+		}
+	}
+
+	for _, fn := range onShutdown {
+		fn()
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	var shutdownErr error
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		shutdownErr = fmt.Errorf("shutdown with %d requests in flight: %w", inFlight.activeRequests(), err)
+	} else if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		shutdownErr = err
+	}
+	// Shutdown has waited for ordinary requests, but not for handlers
+	// that hijacked their connection (e.g. WebSockets).
+	drainErr := inFlight.waitForDrain(shutdownCtx)
+	// The hooks run even if the server didn't stop cleanly, so cleanup
+	// still happens when a request outlives the deadline.
+	return errors.Join(shutdownErr, drainErr, serverHooks.Run(shutdownCtx))
+}
+
+// requestCounter counts the requests running through the handler it
+// wraps. serveUntilSignal keeps one per server.
+type requestCounter struct {
+	n atomic.Int64
+}
+
+// wrap counts the requests running through h.
+func (c *requestCounter) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.n.Add(1)
+		defer c.n.Add(-1)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// activeRequests returns the number of requests currently in flight.
+func (c *requestCounter) activeRequests() int {
+	return int(c.n.Load())
+}
+
+// runningCounter is the requestCounter of the server serveUntilSignal
+// most recently started, published for activeRequests.
+var runningCounter atomic.Pointer[requestCounter]
+
+// activeRequests returns the number of requests in flight on the running
+// server, or 0 when no server is running. It is meant for tests and
+// monitoring.
+func activeRequests() int {
+	c := runningCounter.Load()
+	if c == nil {
+		return 0
+	}
+	return c.activeRequests()
+}
+
+// drainPollInterval is how often waitForDrain rechecks activeRequests.
+const drainPollInterval = 10 * time.Millisecond
+
+// waitForDrain blocks until no requests are in flight or ctx is done.
+func (c *requestCounter) waitForDrain(ctx context.Context) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for c.activeRequests() > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d requests still in flight: %w", c.activeRequests(), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// defaultAddr is used when neither the -addr flag nor $ADDR is set.
+const defaultAddr = ":8080"
+
+// resolveAddr picks the listen address with precedence flag > env >
+// defaultAddr.
+func resolveAddr(flagVal, envVal string) string {
+	if flagVal != "" {
+		return flagVal
+	}
+	if envVal != "" {
+		return envVal
+	}
+	return defaultAddr
+}
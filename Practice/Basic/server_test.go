@@ -0,0 +1,493 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// freeAddr returns a loopback address with a port that was free a moment
+// ago, for servers that only take an address to listen on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// waitUntilServing polls url with client until it gets a response.
+func waitUntilServing(t *testing.T, client *http.Client, url string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := client.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server at %s never came up: %v", url, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// terminate sends SIGTERM to the test process. runServer must already be
+// listening for it, or the process exits.
+func terminate(t *testing.T) {
+	t.Helper()
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitReturn waits for the error sent on done by a server goroutine.
+func waitReturn(t *testing.T, done <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(shutdownTimeout + time.Second):
+		t.Fatal("server did not return after SIGTERM")
+		return nil
+	}
+}
+
+func TestRunServerGracefulShutdown(t *testing.T) {
+	addr := freeAddr(t)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	shutdownCalled := false
+	done := make(chan error, 1)
+	go func() {
+		done <- runServer(addr, handler, func() { shutdownCalled = true })
+	}()
+
+	client := &http.Client{Transport: &http.Transport{}}
+	url := "http://" + addr + "/"
+	waitUntilServing(t, client, url)
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	// Shutdown waits for connections that never sent a request, so do
+	// not leave a spare dialled connection behind.
+	client.CloseIdleConnections()
+	terminate(t)
+	if err := waitReturn(t, done); err != nil {
+		t.Errorf("runServer() = %v, want nil", err)
+	}
+	if !shutdownCalled {
+		t.Error("onShutdown was not called")
+	}
+}
+
+func TestResolveAddr(t *testing.T) {
+	tests := []struct {
+		name, flag, env, want string
+	}{
+		{"flag wins", ":9000", ":9001", ":9000"},
+		{"env when no flag", "", ":9001", ":9001"},
+		{"default", "", "", defaultAddr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolveAddr(tt.flag, tt.env); got != tt.want {
+				t.Errorf("resolveAddr(%q, %q) = %q, want %q", tt.flag, tt.env, got, tt.want)
+			}
+		})
+	}
+}
+
+// selfSignedCert writes a fresh self-signed certificate for 127.0.0.1 and
+// its key to dir and returns the file paths plus a pool trusting it.
+func selfSignedCert(t *testing.T, dir string) (certFile, keyFile string, pool *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	pool = x509.NewCertPool()
+	pool.AppendCertsFromPEM(certPEM)
+	return certFile, keyFile, pool
+}
+
+func TestRunServerTLS(t *testing.T) {
+	certFile, keyFile, pool := selfSignedCert(t, t.TempDir())
+	addr := freeAddr(t)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil {
+			http.Error(w, "not TLS", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("secure"))
+	})
+	done := make(chan error, 1)
+	go func() {
+		done <- runServerTLS(addr, certFile, keyFile, handler)
+	}()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	url := "https://" + addr + "/"
+	waitUntilServing(t, client, url)
+
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "secure" {
+		t.Errorf("response = %d %q, want 200 %q", resp.StatusCode, body, "secure")
+	}
+
+	client.CloseIdleConnections()
+	terminate(t)
+	if err := waitReturn(t, done); err != nil {
+		t.Errorf("runServerTLS() = %v, want nil", err)
+	}
+}
+
+func TestRunServerFlipsReadiness(t *testing.T) {
+	ready, setNotReady := readiness()
+	addr := freeAddr(t)
+	done := make(chan error, 1)
+	go func() {
+		done <- runServer(addr, readyHandler(ready), setNotReady)
+	}()
+
+	client := &http.Client{Transport: &http.Transport{}}
+	waitUntilServing(t, client, "http://"+addr+"/readyz")
+	client.CloseIdleConnections()
+	if !ready() {
+		t.Fatal("not ready before shutdown")
+	}
+
+	terminate(t)
+	if err := waitReturn(t, done); err != nil {
+		t.Errorf("runServer() = %v, want nil", err)
+	}
+	if ready() {
+		t.Error("still ready after shutdown")
+	}
+}
+
+func TestRequestCounter(t *testing.T) {
+	c := &requestCounter{}
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+	h := c.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+	for range 2 {
+		go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	<-started
+	<-started
+	if n := c.activeRequests(); n != 2 {
+		t.Errorf("activeRequests() = %d, want 2", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := c.waitForDrain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("waitForDrain with requests in flight = %v, want deadline exceeded", err)
+	}
+
+	close(release)
+	if err := c.waitForDrain(context.Background()); err != nil {
+		t.Errorf("waitForDrain() = %v, want nil", err)
+	}
+	if n := c.activeRequests(); n != 0 {
+		t.Errorf("activeRequests() after drain = %d, want 0", n)
+	}
+}
+
+// startHeldServer runs runServer on a free port with handler and returns
+// the address and the channel runServer's result arrives on.
+func startHeldServer(t *testing.T, handler http.Handler) (string, <-chan error) {
+	t.Helper()
+	addr := freeAddr(t)
+	done := make(chan error, 1)
+	go func() {
+		done <- runServer(addr, handler)
+	}()
+	client := &http.Client{Transport: &http.Transport{}}
+	waitUntilServing(t, client, "http://"+addr+"/ping")
+	client.CloseIdleConnections()
+	return addr, done
+}
+
+func TestActiveRequests(t *testing.T) {
+	if n := activeRequests(); n != 0 {
+		t.Fatalf("activeRequests() with no server = %d, want 0", n)
+	}
+	started, release := make(chan struct{}), make(chan struct{})
+	addr, done := startHeldServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+	}))
+
+	client := &http.Client{Transport: &http.Transport{}}
+	respDone := make(chan error, 1)
+	go func() {
+		resp, err := client.Get("http://" + addr + "/slow")
+		if err == nil {
+			resp.Body.Close()
+		}
+		respDone <- err
+	}()
+	<-started
+	if n := activeRequests(); n != 1 {
+		t.Errorf("activeRequests() while a request is held = %d, want 1", n)
+	}
+
+	close(release)
+	if err := <-respDone; err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for activeRequests() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("activeRequests() after the request finished = %d, want 0", activeRequests())
+		}
+		time.Sleep(drainPollInterval)
+	}
+
+	client.CloseIdleConnections()
+	terminate(t)
+	if err := waitReturn(t, done); err != nil {
+		t.Errorf("runServer() = %v, want nil", err)
+	}
+	if n := activeRequests(); n != 0 {
+		t.Errorf("activeRequests() after shutdown = %d, want 0", n)
+	}
+}
+
+// assertStillRunning fails if done delivers within a short grace period.
+func assertStillRunning(t *testing.T, done <-chan error) {
+	t.Helper()
+	select {
+	case err := <-done:
+		t.Fatalf("server returned %v while a request was still in flight", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRunServerWaitsForInFlightRequest(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	addr, done := startHeldServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		w.Write([]byte("finished"))
+	}))
+
+	type response struct {
+		body string
+		err  error
+	}
+	got := make(chan response, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/slow")
+		if err != nil {
+			got <- response{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		got <- response{string(body), err}
+	}()
+	<-started
+
+	terminate(t)
+	assertStillRunning(t, done)
+	close(release)
+
+	if err := waitReturn(t, done); err != nil {
+		t.Errorf("runServer() = %v, want nil", err)
+	}
+	if r := <-got; r.err != nil || r.body != "finished" {
+		t.Errorf("held request got %q, %v; want finished, nil", r.body, r.err)
+	}
+}
+
+func TestRunServerWaitsForHijackedConnection(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	addr, done := startHeldServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/hijack" {
+			return
+		}
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		close(started)
+		<-release
+		buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\nConnection: close\r\n\r\nfinished")
+		buf.Flush()
+	}))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET /hijack HTTP/1.1\r\nHost: %s\r\n\r\n", addr)
+	<-started
+
+	// http.Server.Shutdown does not track hijacked connections, so only
+	// the drain step keeps runServer from returning here.
+	terminate(t)
+	assertStillRunning(t, done)
+	close(release)
+
+	if err := waitReturn(t, done); err != nil {
+		t.Errorf("runServer() = %v, want nil", err)
+	}
+	resp, err := io.ReadAll(conn)
+	if err != nil || !strings.HasSuffix(string(resp), "finished") {
+		t.Errorf("hijacked response = %q, %v", resp, err)
+	}
+}
+
+// useServerHooks gives the test an empty serverHooks, restoring the
+// previous hooks afterwards.
+func useServerHooks(t *testing.T) {
+	t.Helper()
+	serverHooks.mu.Lock()
+	prev := serverHooks.hooks
+	serverHooks.hooks = nil
+	serverHooks.mu.Unlock()
+	t.Cleanup(func() {
+		serverHooks.mu.Lock()
+		serverHooks.hooks = prev
+		serverHooks.mu.Unlock()
+	})
+}
+
+func TestRunServerRunsHooks(t *testing.T) {
+	useServerHooks(t)
+	errFlush := errors.New("flush failed")
+	var ran []string
+	serverHooks.Register(func(ctx context.Context) error {
+		ran = append(ran, "db")
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("hook context has no shutdown deadline")
+		}
+		return nil
+	})
+	serverHooks.Register(func(context.Context) error {
+		ran = append(ran, "logs")
+		return errFlush
+	})
+
+	_, done := startHeldServer(t, http.NotFoundHandler())
+	terminate(t)
+	err := waitReturn(t, done)
+	if !errors.Is(err, errFlush) {
+		t.Errorf("runServer() = %v, want %v", err, errFlush)
+	}
+	if want := []string{"logs", "db"}; !slices.Equal(ran, want) {
+		t.Errorf("hooks ran %v, want %v", ran, want)
+	}
+}
+
+func TestRunServerRunsHooksAfterShutdownTimeout(t *testing.T) {
+	useServerHooks(t)
+	prevTimeout := shutdownTimeout
+	shutdownTimeout = 200 * time.Millisecond
+	t.Cleanup(func() { shutdownTimeout = prevTimeout })
+
+	hookRan := false
+	serverHooks.Register(func(context.Context) error {
+		hookRan = true
+		return nil
+	})
+
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	addr, done := startHeldServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stuck" {
+			close(started)
+			<-release
+		}
+	}))
+	go func() {
+		if resp, err := http.Get("http://" + addr + "/stuck"); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-started
+
+	terminate(t)
+	err := waitReturn(t, done)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("runServer() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if !hookRan {
+		t.Error("shutdown hooks skipped after Shutdown timed out")
+	}
+}
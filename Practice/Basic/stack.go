@@ -0,0 +1,46 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+type namedMiddleware struct {
+	name     string
+	priority int
+	mw       func(http.Handler) http.Handler
+}
+
+// MiddlewareStack collects middleware and applies it in priority order,
+// independent of the order Use was called in.
+type MiddlewareStack struct {
+	entries []namedMiddleware
+}
+
+// Use adds mw under name. Lower priorities wrap higher ones, so the
+// lowest priority runs first; equal priorities keep registration order.
+// Registering a name twice is an error.
+func (s *MiddlewareStack) Use(name string, priority int, mw func(http.Handler) http.Handler) error {
+	for _, e := range s.entries {
+		if e.name == name {
+			return fmt.Errorf("middleware %q already registered", name)
+		}
+	}
+	s.entries = append(s.entries, namedMiddleware{name: name, priority: priority, mw: mw})
+	return nil
+}
+
+// Build wraps h in every registered middleware.
+func (s *MiddlewareStack) Build(h http.Handler) http.Handler {
+	sorted := slices.Clone(s.entries)
+	slices.SortStableFunc(sorted, func(a, b namedMiddleware) int {
+		return cmp.Compare(a.priority, b.priority)
+	})
+	mws := make([]func(http.Handler) http.Handler, len(sorted))
+	for i, e := range sorted {
+		mws[i] = e.mw
+	}
+	return Chain(mws...)(h)
+}
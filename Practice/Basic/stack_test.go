@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestMiddlewareStackOrder(t *testing.T) {
+	type reg struct {
+		name     string
+		priority int
+	}
+	tests := []struct {
+		name string
+		regs []reg
+		want []string
+	}{
+		{"already sorted", []reg{{"a", 1}, {"b", 2}, {"c", 3}}, []string{"a", "b", "c", "handler"}},
+		{"reverse registration", []reg{{"c", 30}, {"b", 20}, {"a", 10}}, []string{"a", "b", "c", "handler"}},
+		{"ties keep registration order", []reg{{"x", 5}, {"first", 0}, {"y", 5}}, []string{"first", "x", "y", "handler"}},
+		{"negative priority", []reg{{"log", 0}, {"recover", -10}}, []string{"recover", "log", "handler"}},
+		{"empty", nil, []string{"handler"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			var s MiddlewareStack
+			for _, r := range tt.regs {
+				err := s.Use(r.name, r.priority, func(next http.Handler) http.Handler {
+					return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+						got = append(got, r.name)
+						next.ServeHTTP(w, req)
+					})
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			h := s.Build(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = append(got, "handler")
+			}))
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("order = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddlewareStackDuplicateName(t *testing.T) {
+	var s MiddlewareStack
+	noop := func(next http.Handler) http.Handler { return next }
+	if err := s.Use("auth", 1, noop); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Use("auth", 2, noop); err == nil {
+		t.Error("duplicate Use returned nil, want an error")
+	}
+	if len(s.entries) != 1 {
+		t.Errorf("%d entries after rejected duplicate, want 1", len(s.entries))
+	}
+}
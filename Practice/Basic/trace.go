@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// TraceContext identifies the trace a request belongs to, following the
+// W3C Trace Context traceparent format.
+type TraceContext struct {
+	TraceID  string // 32 lowercase hex digits
+	SpanID   string // 16 hex digits identifying this server's span
+	ParentID string // span ID from the incoming traceparent, if any
+	Flags    string // 2 hex digits, e.g. "01" when sampled
+}
+
+// Traceparent renders tc as a version-00 traceparent header value.
+func (tc TraceContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%s", tc.TraceID, tc.SpanID, tc.Flags)
+}
+
+type traceKey struct{}
+
+// withTraceContext reads the W3C traceparent header and stores the trace
+// in the request context for TraceFromContext. A fresh span ID is created
+// for this hop with the incoming span as its parent. If the header is
+// missing or malformed a new trace is started instead.
+func withTraceContext() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tc, ok := parseTraceparent(r.Header.Get("traceparent"))
+			if ok {
+				tc.ParentID = tc.SpanID
+				tc.SpanID = randomHex(8)
+			} else {
+				tc = TraceContext{TraceID: randomHex(16), SpanID: randomHex(8), Flags: "01"}
+			}
+			ctx := context.WithValue(r.Context(), traceKey{}, tc)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// TraceFromContext returns the trace stored by withTraceContext, if any.
+func TraceFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceKey{}).(TraceContext)
+	return tc, ok
+}
+
+// parseTraceparent parses "version-traceid-spanid-flags". It rejects the
+// invalid version ff and all-zero trace or span IDs.
+func parseTraceparent(v string) (TraceContext, bool) {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 {
+		return TraceContext{}, false
+	}
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+	// Only version 00 has a fixed layout; later versions may append fields.
+	if version == "00" && len(parts) != 4 {
+		return TraceContext{}, false
+	}
+	if !isLowerHex(version, 2) || version == "ff" ||
+		!isLowerHex(traceID, 32) || traceID == strings.Repeat("0", 32) ||
+		!isLowerHex(spanID, 16) || spanID == strings.Repeat("0", 16) ||
+		!isLowerHex(flags, 2) {
+		return TraceContext{}, false
+	}
+	return TraceContext{TraceID: traceID, SpanID: spanID, Flags: flags}, true
+}
+
+// isLowerHex reports whether s is exactly n lowercase hex digits.
+func isLowerHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// randomHex returns n random bytes encoded as 2n hex digits.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithTraceContext(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	tests := []struct {
+		name        string
+		header      string
+		wantTraceID string // empty means a new trace
+		wantParent  string
+		wantFlags   string
+	}{
+		{"valid", "00-" + traceID + "-" + spanID + "-01", traceID, spanID, "01"},
+		{"future version with extra field", "01-" + traceID + "-" + spanID + "-00-extra", traceID, spanID, "00"},
+		{"malformed", "00-nothex-" + spanID + "-01", "", "", "01"},
+		{"uppercase", "00-4BF92F3577B34DA6A3CE929D0E0E4736-" + spanID + "-01", "", "", "01"},
+		{"zero trace", "00-00000000000000000000000000000000-" + spanID + "-01", "", "", "01"},
+		{"version ff", "ff-" + traceID + "-" + spanID + "-01", "", "", "01"},
+		{"extra field in v00", "00-" + traceID + "-" + spanID + "-01-x", "", "", "01"},
+		{"no header", "", "", "", "01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tc TraceContext
+			var ok bool
+			h := withTraceContext()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tc, ok = TraceFromContext(r.Context())
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("traceparent", tt.header)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			if !ok {
+				t.Fatal("no trace in context")
+			}
+			if tt.wantTraceID != "" && tc.TraceID != tt.wantTraceID {
+				t.Errorf("TraceID = %q, want %q", tc.TraceID, tt.wantTraceID)
+			}
+			if tt.wantTraceID == "" && (!isLowerHex(tc.TraceID, 32) || tc.TraceID == traceID) {
+				t.Errorf("TraceID = %q, want a fresh 32-digit ID", tc.TraceID)
+			}
+			if !isLowerHex(tc.SpanID, 16) || tc.SpanID == spanID {
+				t.Errorf("SpanID = %q, want a fresh 16-digit ID", tc.SpanID)
+			}
+			if tc.ParentID != tt.wantParent || tc.Flags != tt.wantFlags {
+				t.Errorf("ParentID, Flags = %q, %q; want %q, %q", tc.ParentID, tc.Flags, tt.wantParent, tt.wantFlags)
+			}
+			if got, ok := parseTraceparent(tc.Traceparent()); !ok || got.TraceID != tc.TraceID || got.SpanID != tc.SpanID {
+				t.Errorf("Traceparent() = %q does not round-trip", tc.Traceparent())
+			}
+		})
+	}
+}
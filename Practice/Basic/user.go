@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+type userKey struct{}
+
+// WithUser returns a copy of ctx carrying userID.
+func WithUser(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userKey{}, userID)
+}
+
+// UserFromContext returns the user ID stored by WithUser, if any.
+func UserFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(userKey{}).(string)
+	return id, ok && id != ""
+}
+
+// withUserFromHeader stores the user ID from the named request header in
+// the request context, so handlers and context-aware button actions can
+// tell who is calling. Requests without the header pass through with no
+// user set.
+func withUserFromHeader(header string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if id := r.Header.Get(header); id != "" {
+				r = r.WithContext(WithUser(r.Context(), id))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUserContext(t *testing.T) {
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		want   string
+		wantOK bool
+	}{
+		{"stored", WithUser(context.Background(), "u-42"), "u-42", true},
+		{"not set", context.Background(), "", false},
+		{"empty ID", WithUser(context.Background(), ""), "", false},
+		{"innermost wins", WithUser(WithUser(context.Background(), "outer"), "inner"), "inner", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := UserFromContext(tt.ctx)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("UserFromContext() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestWithUserFromHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+		wantOK bool
+	}{
+		{"header set", "u-7", "u-7", true},
+		{"header missing", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			var ok bool
+			h := withUserFromHeader("X-User-ID")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, ok = UserFromContext(r.Context())
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("X-User-ID", tt.header)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("user = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import "sync"
+
+// WorkerPool runs submitted closures on a fixed number of goroutines.
+type WorkerPool struct {
+	tasks chan func()
+	wg    sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewWorkerPool starts a pool with the given number of workers (at least
+// one).
+func NewWorkerPool(workers int) *WorkerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &WorkerPool{tasks: make(chan func())}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer p.wg.Done()
+			for task := range p.tasks {
+				task()
+			}
+		}()
+	}
+	return p
+}
+
+// Submit queues task, blocking until a worker is free to take it. It
+// panics if called after Wait.
+func (p *WorkerPool) Submit(task func()) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		panic("WorkerPool: Submit called after Wait")
+	}
+	p.tasks <- task
+}
+
+// Wait stops accepting tasks and blocks until every submitted task has
+// finished. Calling Wait more than once is safe.
+func (p *WorkerPool) Wait() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.tasks)
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+// ResultPool is a WorkerPool whose tasks return a value and an error,
+// collected as Results.
+type ResultPool[T any] struct {
+	pool *WorkerPool
+
+	submitMu sync.RWMutex
+	closed   bool
+
+	mu      sync.Mutex
+	results []Result[T]
+}
+
+// NewResultPool starts a ResultPool with the given number of workers.
+func NewResultPool[T any](workers int) *ResultPool[T] {
+	return &ResultPool[T]{pool: NewWorkerPool(workers)}
+}
+
+// Submit queues task. Like WorkerPool.Submit it panics once Results has
+// been called, without reserving a result slot.
+func (p *ResultPool[T]) Submit(task func() (T, error)) {
+	p.submitMu.RLock()
+	defer p.submitMu.RUnlock()
+	if p.closed {
+		panic("ResultPool: Submit called after Results")
+	}
+
+	p.mu.Lock()
+	i := len(p.results)
+	p.results = append(p.results, Result[T]{})
+	p.mu.Unlock()
+
+	p.pool.Submit(func() {
+		r := resultOf(task())
+		p.mu.Lock()
+		p.results[i] = r
+		p.mu.Unlock()
+	})
+}
+
+// Results waits for all submitted tasks and returns their results in
+// submission order.
+func (p *ResultPool[T]) Results() []Result[T] {
+	p.submitMu.Lock()
+	p.closed = true
+	p.submitMu.Unlock()
+
+	p.pool.Wait()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Result[T](nil), p.results...)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWorkerPool(t *testing.T) {
+	const tasks = 1000
+	var ran atomic.Int64
+	p := NewWorkerPool(8)
+	for range tasks {
+		p.Submit(func() { ran.Add(1) })
+	}
+	p.Wait()
+	p.Wait()
+
+	if got := ran.Load(); got != tasks {
+		t.Errorf("%d tasks ran, want %d", got, tasks)
+	}
+}
+
+func TestWorkerPoolSubmitAfterWait(t *testing.T) {
+	p := NewWorkerPool(0)
+	p.Wait()
+	defer func() {
+		if recover() == nil {
+			t.Error("Submit after Wait did not panic")
+		}
+	}()
+	p.Submit(func() {})
+}
+
+func TestResultPool(t *testing.T) {
+	const tasks = 200
+	p := NewResultPool[int](8)
+	for i := range tasks {
+		p.Submit(func() (int, error) {
+			if i%3 == 0 {
+				return 0, fmt.Errorf("task %d failed", i)
+			}
+			return i * 10, nil
+		})
+	}
+
+	results := p.Results()
+	if len(results) != tasks {
+		t.Fatalf("got %d results, want %d", len(results), tasks)
+	}
+	for i, r := range results {
+		v, err := r.Unwrap()
+		if i%3 == 0 {
+			if !r.IsErr() || err.Error() != fmt.Sprintf("task %d failed", i) {
+				t.Errorf("result %d = %v, %v; want task %d's error", i, v, err, i)
+			}
+			continue
+		}
+		if r.IsErr() || v != i*10 {
+			t.Errorf("result %d = %v, %v; want %d, nil", i, v, err, i*10)
+		}
+	}
+}
+
+func TestResultPoolSubmitAfterResults(t *testing.T) {
+	p := NewResultPool[string](2)
+	p.Submit(func() (string, error) { return "only", nil })
+	p.Results()
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Submit after Results did not panic")
+			}
+		}()
+		p.Submit(func() (string, error) { return "late", nil })
+	}()
+
+	results := p.Results()
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	if v, err := results[0].Unwrap(); v != "only" || err != nil {
+		t.Errorf("result = %q, %v; want only, nil", v, err)
+	}
+}
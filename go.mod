@@ -0,0 +1,8 @@
+module github.com/ShindeSatish/golang-guide
+
+go 1.23.0
+
+require (
+	golang.org/x/exp v0.0.0-20250218142911-aa4b98e5adaa
+	golang.org/x/time v0.11.0
+)